@@ -33,6 +33,23 @@ func BenchmarkWithReflext(b *testing.B) {
 	}
 }
 
+func TestMatchDoesNotAllocate(t *testing.T) {
+	examples := map[*Reflext]interface{}{
+		MustCompile("func(%T, *struct, map[int]bool) error", someInterfaceType): exampleFunc,
+		MustCompile("func({_}, _*, ...{int | uint}) (*{struct{ A _; _ int; ... }}, error?)"): func(bool, string, ...uint) *struct{ A, B int } {
+			return nil
+		},
+	}
+	for r, value := range examples {
+		if !r.Match(value) {
+			t.Errorf("%s must match %T", r, value)
+		}
+		if allocs := testing.AllocsPerRun(100, func() { r.Match(value) }); allocs != 0 {
+			t.Errorf("%s: %v allocations per match", r, allocs)
+		}
+	}
+}
+
 func BenchmarkByHand(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		if ok := matchByHand(exampleFunc); !ok {
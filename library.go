@@ -0,0 +1,451 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reflext
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/scanner"
+)
+
+// Definitions collects named patterns, which patterns compiled with the
+// resulting Library refer to as @Name, or @Name[E, ...] when the definition
+// has parameters.
+type Definitions struct {
+	defs     []*definition
+	universe *Universe
+	err      error
+}
+
+// Define adds a definition such as
+//
+//	Handler := func(%T, *{struct}) error
+//	Pair[A, B] := struct{ First A; Second B }
+//
+// where args are the arguments of the %T verbs of the pattern. Definitions
+// are only checked when building the library.
+func (d *Definitions) Define(def string, args ...interface{}) {
+	d.define(tokenize(def), args)
+}
+
+func (d *Definitions) define(tokens []token, args []interface{}) {
+	if d.err != nil {
+		return
+	}
+	p := &parser{tokens: tokens, index: 0}
+	def, ok := p.parseDefinition()
+	if !ok {
+		d.err = p.err
+		return
+	}
+	def.args = args
+	d.defs = append(d.defs, def)
+}
+
+func (d *Definitions) bind(pos scanner.Position, name string, typ reflect.Type) {
+	if d.err != nil {
+		return
+	}
+	if keywords[name] {
+		d.err = errorAt(pos, "%s is a keyword", name)
+		return
+	}
+	if _, ok := d.universe.lookup(name); ok {
+		d.err = errorAt(pos, "%s is already bound", name)
+		return
+	}
+	d.universe = d.universe.With(name, typ)
+}
+
+// Library checks the definitions, reporting syntax errors, references to
+// undefined patterns, references with the wrong number of arguments, and
+// cycles between definitions.
+func (d *Definitions) Library() (*Library, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	l := &Library{make(map[string]*definition), d.universe}
+	for _, def := range d.defs {
+		if _, ok := l.defs[def.name]; ok {
+			return nil, errorAt(def.pos, "%s is defined more than once", def.name)
+		}
+		def := *def
+		def.lib, def.universe = l, d.universe
+		l.defs[def.name] = &def
+	}
+	instances := make(map[*definition][]instance)
+	for _, def := range d.defs {
+		// Parameters stand for any type while checking, so that they also fit
+		// where a type is expected, as in exact[T].
+		args := make([]expression, len(def.params))
+		for i := range args {
+			args[i] = &exact{types["any"]}
+		}
+		p := &parser{lib: l, instances: instances}
+		if _, ok := p.expand(def.name, args); !ok {
+			if def.pos.Filename != "" {
+				return nil, p.err
+			}
+			return nil, fmt.Errorf("in definition of %s: %v", def.name, p.err)
+		}
+	}
+	return l, nil
+}
+
+// definition is a pattern to be parsed where it is used, in the scope of its
+// library and universe. Patterns spliced with %v are unnamed definitions.
+type definition struct {
+	name   string
+	pos    scanner.Position
+	params []string
+	body   []token
+	args   []interface{}
+
+	lib      *Library
+	universe *Universe
+}
+
+func (p *parser) parseDefinition() (*definition, bool) {
+	name, _ := p.next()
+	if !isIdent(name) || name == "_" {
+		p.fail("unable to parse definition, expected a name")
+		return nil, false
+	}
+	def := &definition{name: name, pos: p.tokens[0].pos}
+	if text, _ := p.peek(); text == "[" {
+		p.next()
+		seen := make(map[string]bool)
+		for {
+			param, _ := p.next()
+			if !isIdent(param) || param == "_" || seen[param] {
+				p.fail("unable to parse parameters of %s", name)
+				return nil, false
+			}
+			seen[param] = true
+			def.params = append(def.params, param)
+			if text, _ := p.next(); text == "]" {
+				break
+			} else if text != "," {
+				p.fail("unable to parse parameters of %s", name)
+				return nil, false
+			}
+		}
+	}
+	if !p.consume(":") || !p.consume("=") {
+		p.fail("unable to parse definition of %s, expected :=", name)
+		return nil, false
+	}
+	def.body = p.tokens[p.index:]
+	return def, true
+}
+
+// ParseLibrary reads a library file, such as
+//
+//	// Handlers take a context and a request.
+//	bind Context = %T
+//	Handler := func(Context, *{struct}) error
+//	Pair[A, B] := struct{
+//		First A
+//		Second B
+//	}
+//
+// where each bind declaration binds a name to the type of the next argument.
+// Declarations end at the end of a line, unless within brackets. Within the
+// body of a struct or an interface, line breaks separate fields and methods
+// as in Go. Errors are reported with the filename, line and column where they
+// occur.
+//
+// Library files read with ParseLibrary cannot import others, see
+// ParseLibraryFS.
+func ParseLibrary(filename string, r io.Reader, args ...interface{}) (*Library, error) {
+	return (*Universe)(nil).ParseLibrary(filename, r, args...)
+}
+
+// ParseLibraryFS reads the library file name from fsys, which may be an
+// embed.FS, as ParseLibrary does. Library files may also import others, as in
+//
+//	import "common.reflext"
+//
+// where the path is relative to the directory of the importing file. The
+// definitions and bind declarations of imported files are added to the
+// library, and bind declarations take their arguments in the order in which
+// they are read, with imported files read where they are imported. Files
+// imported more than once are only read the first time.
+func ParseLibraryFS(fsys fs.FS, name string, args ...interface{}) (*Library, error) {
+	return (*Universe)(nil).ParseLibraryFS(fsys, name, args...)
+}
+
+func (d *Definitions) parseLibrary(fsys fs.FS, filename string, r io.Reader, args []interface{}) (*Library, error) {
+	l := &loader{defs: d, fsys: fsys, args: args, read: make(map[string]bool)}
+	var err error
+	if r != nil {
+		err = l.load(filename, r)
+	} else {
+		err = l.open(scanner.Position{}, filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l.argsIndex < len(args) {
+		return nil, fmt.Errorf("%s: %d arguments but %d bind declarations", filename, len(args), l.argsIndex)
+	}
+	return d.Library()
+}
+
+// loader reads library files into defs. reading holds the files being read,
+// outermost first, and read all the files read so far.
+type loader struct {
+	defs      *Definitions
+	fsys      fs.FS
+	args      []interface{}
+	argsIndex int
+	reading   []string
+	read      map[string]bool
+}
+
+// open reads the file name of the file system, imported at pos.
+func (l *loader) open(pos scanner.Position, name string) error {
+	for i, n := range l.reading {
+		if n == name {
+			cycle := append(l.reading[i:], name)
+			return errorAt(pos, "import cycle %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if l.read[name] {
+		return nil
+	}
+	l.read[name] = true
+	f, err := l.fsys.Open(name)
+	if err != nil {
+		return errorAt(pos, "%v", err)
+	}
+	defer f.Close()
+	return l.load(name, f)
+}
+
+func (l *loader) load(filename string, r io.Reader) error {
+	var s scanner.Scanner
+	s.Init(r)
+	s.Filename = filename
+	s.Whitespace ^= 1 << '\n'
+	var err error
+	s.Error = func(s *scanner.Scanner, msg string) {
+		if pos := s.Position; err == nil {
+			if !pos.IsValid() {
+				pos = s.Pos()
+			}
+			err = errorAt(pos, "%s", msg)
+		}
+	}
+	tokens := scan(&s)
+	if err != nil {
+		return err
+	}
+
+	l.reading = append(l.reading, filename)
+	defer func() {
+		l.reading = l.reading[:len(l.reading)-1]
+	}()
+	for _, decl := range declarations(tokens) {
+		switch decl[0].text {
+		case "import":
+			name, err := strconv.Unquote(decl[len(decl)-1].text)
+			if len(decl) != 2 || err != nil {
+				return errorAt(decl[0].pos, "unable to parse import declaration, expected import \"file\"")
+			}
+			if l.fsys == nil {
+				return errorAt(decl[1].pos, "unable to import %s without a file system", name)
+			}
+			if err := l.open(decl[1].pos, path.Join(path.Dir(filename), name)); err != nil {
+				return err
+			}
+		case "bind":
+			if len(decl) != 5 || !isIdent(decl[1].text) || decl[1].text == "_" || decl[2].text != "=" || decl[3].text != "%" || decl[4].text != "T" {
+				return errorAt(decl[0].pos, "unable to parse bind declaration, expected bind Name = %%T")
+			}
+			if len(l.args) <= l.argsIndex {
+				return errorAt(decl[3].pos, "missing argument for %s", decl[1].text)
+			}
+			l.defs.bind(decl[1].pos, decl[1].text, typeOfArg(l.args[l.argsIndex]))
+			l.argsIndex++
+		default:
+			l.defs.define(decl, nil)
+		}
+	}
+	return nil
+}
+
+// declarations splits tokens on the line breaks which are not within
+// brackets, and turns those ending fields and methods into semicolons.
+func declarations(tokens []token) [][]token {
+	var (
+		decls [][]token
+		decl  []token
+		// bodies tells, for each open bracket, whether it opens the body of a
+		// struct or an interface.
+		bodies []bool
+	)
+	for _, tok := range tokens {
+		switch tok.text {
+		case "(", "[", "{":
+			body := false
+			if n := len(decl); tok.text == "{" && n != 0 {
+				body = decl[n-1].text == "struct" || decl[n-1].text == "interface"
+			}
+			bodies = append(bodies, body)
+		case ")", "]", "}":
+			if len(bodies) != 0 {
+				bodies = bodies[:len(bodies)-1]
+			}
+		case "\n":
+			if len(bodies) == 0 && len(decl) != 0 {
+				decls = append(decls, decl)
+				decl = nil
+			} else if n := len(bodies); n != 0 && bodies[n-1] {
+				if last := decl[len(decl)-1].text; last != "{" && last != ";" {
+					decl = append(decl, token{";", tok.pos})
+				}
+			}
+			continue
+		}
+		decl = append(decl, tok)
+	}
+	if len(decl) != 0 {
+		decls = append(decls, decl)
+	}
+	return decls
+}
+
+// Library is a checked set of definitions. It is safe for concurrent use.
+type Library struct {
+	defs     map[string]*definition
+	universe *Universe
+}
+
+func (l *Library) Compile(s string, args ...interface{}) (*Reflext, error) {
+	p := &parser{
+		tokens:   tokenize(s),
+		index:    0,
+		args:     args,
+		lib:      l,
+		universe: l.universe,
+	}
+	return p.compile(s)
+}
+
+func (l *Library) MustCompile(s string, args ...interface{}) *Reflext {
+	r, err := l.Compile(s, args...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// expand parses the definition name, with its parameters bound to args.
+func (p *parser) expand(name string, args []expression) (expression, bool) {
+	var def *definition
+	if p.lib != nil {
+		def = p.lib.defs[name]
+	}
+	if def == nil {
+		return p.fail("@%s is not defined", name)
+	}
+	if len(args) != len(def.params) {
+		return p.fail("@%s expects %d arguments, got %d", name, len(def.params), len(args))
+	}
+	for i, n := range p.expanding {
+		if n == name {
+			cycle := append(p.expanding[i:], name)
+			return p.fail("cycle between definitions %s", strings.Join(cycle, " -> "))
+		}
+	}
+	return p.instantiate(def, args)
+}
+
+// instance is a definition parsed with given arguments.
+type instance struct {
+	args      []expression
+	exp       expression
+	recursive bool
+}
+
+// instantiate parses the body of def, with its parameters bound to args.
+// Groups of the body are numbered after those already parsed, and its
+// backreferences are relative to its first group. Bodies without groups do
+// not depend on where they are used, so they are parsed once per arguments
+// and shared, which keeps definitions referring to others several times from
+// growing exponentially.
+func (p *parser) instantiate(def *definition, args []expression) (expression, bool) {
+	if p.instances == nil {
+		p.instances = make(map[*definition][]instance)
+	}
+	for _, inst := range p.instances[def] {
+		if sameExps(inst.args, args) {
+			p.recursive = p.recursive || inst.recursive
+			return inst.exp, true
+		}
+	}
+	body := &parser{
+		tokens:    def.body,
+		index:     0,
+		args:      def.args,
+		names:     p.names,
+		lib:       def.lib,
+		universe:  def.universe,
+		params:    make(map[string]expression),
+		base:      len(p.names),
+		instances: p.instances,
+	}
+	if def.name != "" {
+		body.expanding = append(append([]string(nil), p.expanding...), def.name)
+	}
+	for i, param := range def.params {
+		body.params[param] = args[i]
+	}
+	exp, ok := body.parseExp()
+	if ok && body.index != len(body.tokens) {
+		body.failAt(body.index, "unable to parse @%s", def.name)
+	} else if !ok {
+		body.fail("unable to parse @%s", def.name)
+	} else {
+		body.checkArgs()
+	}
+	p.names = body.names
+	p.backrefs = p.backrefs || body.backrefs
+	p.recursive = p.recursive || body.recursive
+	if body.err != nil {
+		p.err = body.err
+		return nil, false
+	}
+	if len(body.names) == body.base {
+		p.instances[def] = append(p.instances[def], instance{args, exp, body.recursive})
+	}
+	return exp, true
+}
+
+func sameExps(a, b []expression) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
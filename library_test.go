@@ -0,0 +1,322 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reflext
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing/fstest"
+
+	. "gopkg.in/check.v1"
+)
+
+func testLibrary(c *C) *Library {
+	var defs Definitions
+	defs.Define("Handler := func(%T, *{kind[struct]}) error", readerType)
+	defs.Define("Pair[A, B] := struct{ First A; Second B }")
+	defs.Define("Same[T] := func({T}) \\1")
+	defs.Define("Handlers := []@Handler | map[string]@Handler")
+	lib, err := defs.Library()
+	c.Assert(err, IsNil)
+	return lib
+}
+
+func (_ *ReflextSuite) TestLibrary_compile(c *C) {
+	lib := testLibrary(c)
+	examples := map[string]string{
+		"@Handler":                  "func(io.Reader, *{kind[struct]}) error",
+		"@Pair[int, _]":             "struct{ First int; Second _ }",
+		"@Pair[@Pair[int, int], _]": "struct{ First struct{ First int; Second int }; Second _ }",
+		"{_} | @Same[int]":          "{_} | func({int}) \\2",
+		"@Handlers":                 "[]func(io.Reader, *{kind[struct]}) error | map[string]func(io.Reader, *{kind[struct]}) error",
+	}
+	for s, expected := range examples {
+		c.Log(s)
+		r, err := lib.Compile(s)
+		c.Assert(err, IsNil)
+		c.Assert(r.String(), Equals, expected)
+	}
+}
+
+func (_ *ReflextSuite) TestLibrary_match(c *C) {
+	lib := testLibrary(c)
+
+	r := lib.MustCompile("func(@Handler) {_}")
+	c.Assert(r.NumSubexp(), Equals, 2)
+	m, ok := r.FindMatch(func(func(int, *struct{ A int }) error) bool { return false })
+	c.Assert(ok, Equals, false)
+	m, ok = r.FindMatch(func(func(reader, *struct{ A int }) error) bool { return false })
+	c.Assert(ok, Equals, true)
+	group, _ := m.Group(0)
+	c.Assert(group, Equals, reflect.TypeOf(struct{ A int }{}))
+	group, _ = m.Group(1)
+	c.Assert(group, Equals, reflect.TypeOf(true))
+
+	r = lib.MustCompile("@Same[_]")
+	c.Assert(r.Match(func(int) int { return 0 }), Equals, true)
+	c.Assert(r.Match(func(int) bool { return false }), Equals, false)
+}
+
+func (_ *ReflextSuite) TestLibrary_typeParams(c *C) {
+	var defs Definitions
+	defs.Define("Box[T] := exact[T]")
+	defs.Define("Impl[T] := impl[T]")
+	defs.Define("Conv[T] := convertible[T]")
+	defs.Define("Assign[T] := assignable[[]T]")
+	lib, err := defs.Library()
+	c.Assert(err, IsNil)
+
+	c.Assert(lib.MustCompile("@Box[int]").Match(0), Equals, true)
+	c.Assert(lib.MustCompile("@Impl[error]").MatchInType(errorType), Equals, true)
+	c.Assert(lib.MustCompile("@Conv[int]").Match(celsius(0)), Equals, true)
+	c.Assert(lib.MustCompile("@Assign[byte]").Match(bytesAlias(nil)), Equals, true)
+
+	_, err = lib.Compile("@Box[_]")
+	c.Assert(err, ErrorMatches, "exact\\[T\\] expects a type, got _")
+	_, err = lib.Compile("@Impl[int]")
+	c.Assert(err, ErrorMatches, "impl\\[T\\] expects an interface type, got int")
+}
+
+func (_ *ReflextSuite) TestLibrary_negatedCaptures(c *C) {
+	var defs Definitions
+	defs.Define("Not[T] := !T")
+	defs.Define("NotSlice[T] := ![]T")
+	lib, err := defs.Library()
+	c.Assert(err, IsNil)
+
+	c.Assert(lib.MustCompile("@Not[int]").Match(0), Equals, false)
+	for _, s := range []string{"@Not[{int}]", "@NotSlice[{int} | uint]", "@Not[map[_]{k: _}]"} {
+		c.Log(s)
+		_, err := lib.Compile(s)
+		c.Assert(err, ErrorMatches, "cannot capture under a negation, in .*")
+	}
+}
+
+func (_ *ReflextSuite) TestLibrary_sharedDefinitions(c *C) {
+	var defs Definitions
+	defs.Define("D0 := int")
+	defs.Define("P0[T] := []T")
+	for i := 1; i <= 40; i++ {
+		defs.Define(fmt.Sprintf("D%d := @D%d | *@D%d", i, i-1, i-1))
+		defs.Define(fmt.Sprintf("P%d[T] := @P%d[T] | *@P%d[T]", i, i-1, i-1))
+	}
+	lib, err := defs.Library()
+	c.Assert(err, IsNil)
+
+	c.Assert(lib.MustCompile("@D40").Match(new(int)), Equals, true)
+	c.Assert(lib.MustCompile("@P40[int]").Match(new([]int)), Equals, true)
+	typs, ok := lib.MustCompile("@P40[{int}]").FindAll(new([]int))
+	c.Assert(ok, Equals, true)
+	c.Assert(typs, DeepEquals, []reflect.Type{types["int"]})
+
+	defs.Define("G := {int} | @G0 | @G0")
+	defs.Define("G0 := {_}")
+	lib, err = defs.Library()
+	c.Assert(err, IsNil)
+	c.Assert(lib.MustCompile("@G").NumSubexp(), Equals, 3)
+}
+
+func (_ *ReflextSuite) TestLibrary_bad(c *C) {
+	examples := [][]string{
+		{"Handler"},
+		{"Handler = int"},
+		{":= int"},
+		{"Pair[A, A] := struct{ First A; Second A }"},
+		{"Pair[] := int"},
+		{"Pair[A B] := int"},
+		{"A := int", "A := uint"},
+		{"A := int |"},
+		{"A := @B"},
+		{"A := @B[int]", "B := int"},
+		{"A[T] := []T", "B := @A"},
+		{"A := []@B", "B := map[int]@A"},
+		{"A := *@A"},
+		{"A := {a: _}", "B := func(@A, @A)"},
+	}
+	for _, srcs := range examples {
+		c.Log(srcs)
+		var defs Definitions
+		for _, src := range srcs {
+			defs.Define(src)
+		}
+		_, err := defs.Library()
+		c.Assert(err, NotNil)
+	}
+}
+
+func (_ *ReflextSuite) TestLibrary_compileBad(c *C) {
+	lib := testLibrary(c)
+	examples := map[string]string{
+		"@Unknown":         "@Unknown is not defined",
+		"@Pair[int]":       "@Pair expects 2 arguments, got 1",
+		"@Handler[int]":    "@Handler expects 0 arguments, got 1",
+		"func(A)":          "unknown identifier A",
+		"@Pair[int, @Foo]": "@Foo is not defined",
+	}
+	for s, expected := range examples {
+		c.Log(s)
+		_, err := lib.Compile(s)
+		c.Assert(err, ErrorMatches, expected)
+	}
+	_, err := Compile("@Handler")
+	c.Assert(err, ErrorMatches, "@Handler is not defined")
+}
+
+func (_ *ReflextSuite) TestLibrary_cycle(c *C) {
+	var defs Definitions
+	defs.Define("A := []@B")
+	defs.Define("B := map[int]@C")
+	defs.Define("C := *@A | int")
+	_, err := defs.Library()
+	c.Assert(err, ErrorMatches, "in definition of A: cycle between definitions A -> B -> C -> A")
+}
+
+func (_ *ReflextSuite) TestParseLibrary(c *C) {
+	file := `// Handlers take a context and a request.
+bind Context = %T
+
+Handler := func(Context, *{struct}) error // the request is captured
+Pair[A, B] := struct{
+	First A;
+	Second B
+}
+Handlers := map[string]@Handler
+Point := struct{
+	X int
+	Y int "json:\"y\""
+	...
+}
+Closer := interface{
+	Close() error;
+}
+`
+	lib, err := ParseLibrary("lib.reflext", strings.NewReader(file), readerType)
+	c.Assert(err, IsNil)
+
+	r := lib.MustCompile("@Handlers")
+	c.Assert(r.String(), Equals, "map[string]func(io.Reader, *{kind[struct]}) error")
+	c.Assert(r.Match(map[string]func(reader, *struct{}) error{}), Equals, true)
+	c.Assert(r.Match(map[string]func(int, *struct{}) error{}), Equals, false)
+
+	r = lib.MustCompile("@Pair[Context, _]")
+	c.Assert(r.String(), Equals, "struct{ First io.Reader; Second _ }")
+
+	r = lib.MustCompile("@Point")
+	c.Assert(r.String(), Equals, "struct{ X int; Y int `json:\"y\"`; ... }")
+	c.Assert(lib.MustCompile("@Closer").String(), Equals, "interface{ Close() error }")
+}
+
+func (_ *ReflextSuite) TestParseLibrary_errors(c *C) {
+	examples := []struct {
+		file     string
+		args     []interface{}
+		expected string
+	}{
+		{"A := int\nB := @C\n", nil, "lib.reflext:2:7: @C is not defined"},
+		{"A := []@B\nB := *@A", nil, "lib.reflext:2:8: cycle between definitions A -> B -> A"},
+		{"A := map[int]\n", nil, "lib.reflext:1:13: unable to parse @A"},
+		{"A := int int", nil, "lib.reflext:1:10: unable to parse @A"},
+		{"A := struct{\n\tX int Y int\n}", nil, "lib.reflext:2:8: unable to parse @A"},
+		{"A = int", nil, "lib.reflext:1:3: unable to parse definition of A, expected :="},
+		{"A := int\n\nA := uint", nil, "lib.reflext:3:1: A is defined more than once"},
+		{"A := struct{ B int `json\n", nil, "lib.reflext:1:20: literal not terminated"},
+		{"bind X = int", nil, "lib.reflext:1:1: unable to parse bind declaration, expected bind Name = %T"},
+		{"bind X = %T", nil, "lib.reflext:1:10: missing argument for X"},
+		{"bind X = %T\nbind X = %T", []interface{}{0, 0}, "lib.reflext:2:6: X is already bound"},
+		{"bind error = %T", []interface{}{0}, "lib.reflext:1:6: error is already bound"},
+		{"bind struct = %T", []interface{}{0}, "lib.reflext:1:6: struct is a keyword"},
+		{"A := int", []interface{}{0}, "lib.reflext: 1 arguments but 0 bind declarations"},
+	}
+	for _, eg := range examples {
+		c.Log(eg.file)
+		_, err := ParseLibrary("lib.reflext", strings.NewReader(eg.file), eg.args...)
+		c.Assert(err, ErrorMatches, eg.expected)
+	}
+}
+
+func (_ *ReflextSuite) TestParseLibraryFS(c *C) {
+	fsys := fstest.MapFS{
+		"lib/handlers.reflext": {Data: []byte(`import "common.reflext"
+import "types/pairs.reflext"
+
+bind Context = %T
+Handler := func(Context, *{@Request}) error
+`)},
+		"lib/common.reflext": {Data: []byte(`// Requests are structs.
+bind Request = %T
+Request := Request | struct{ ... }
+`)},
+		"lib/types/pairs.reflext": {Data: []byte(`import "../common.reflext"
+
+Pair[A, B] := struct{ First A; Second B }
+`)},
+	}
+	lib, err := ParseLibraryFS(fsys, "lib/handlers.reflext", point{}, readerType)
+	c.Assert(err, IsNil)
+
+	r := lib.MustCompile("@Handler")
+	c.Assert(r.String(), Equals, "func(io.Reader, *{reflext.point | struct{ ... }}) error")
+	c.Assert(r.Match(func(reader, *point) error { return nil }), Equals, true)
+	c.Assert(lib.MustCompile("@Pair[Request, _]").Match(struct{ First, Second point }{}), Equals, true)
+
+	lib, err = testUniverse().ParseLibraryFS(fsys, "lib/types/pairs.reflext", point{})
+	c.Assert(err, IsNil)
+	c.Assert(lib.MustCompile("@Pair[io.Reader, @Request]").Match(struct {
+		First  reader
+		Second struct{}
+	}{}), Equals, true)
+}
+
+func (_ *ReflextSuite) TestParseLibraryFS_errors(c *C) {
+	examples := []struct {
+		files    map[string]string
+		args     []interface{}
+		expected string
+	}{
+		{map[string]string{"a.reflext": `import "b.reflext"`}, nil, "a.reflext:1:8: open b.reflext: file does not exist"},
+		{map[string]string{"a.reflext": "import b.reflext"}, nil, "a.reflext:1:1: unable to parse import declaration, expected import \"file\""},
+		{map[string]string{"a.reflext": `import "b.reflext" "c.reflext"`}, nil, "a.reflext:1:1: unable to parse import declaration, expected import \"file\""},
+		{map[string]string{
+			"a.reflext":     `import "dir/b.reflext"`,
+			"dir/b.reflext": `import "../a.reflext"`,
+		}, nil, "dir/b.reflext:1:8: import cycle a.reflext -> dir/b.reflext -> a.reflext"},
+		{map[string]string{
+			"a.reflext": "import \"b.reflext\"\nA := @B",
+			"b.reflext": "B := @C",
+		}, nil, "b.reflext:1:7: @C is not defined"},
+		{map[string]string{
+			"a.reflext": "import \"b.reflext\"\nA := int",
+			"b.reflext": "A := uint",
+		}, nil, "a.reflext:2:1: A is defined more than once"},
+		{map[string]string{
+			"a.reflext": "import \"b.reflext\"\nbind A = %T",
+			"b.reflext": "bind B = %T",
+		}, []interface{}{0}, "a.reflext:2:10: missing argument for A"},
+	}
+	for _, eg := range examples {
+		c.Log(eg.files)
+		fsys := make(fstest.MapFS)
+		for name, file := range eg.files {
+			fsys[name] = &fstest.MapFile{Data: []byte(file)}
+		}
+		_, err := ParseLibraryFS(fsys, "a.reflext", eg.args...)
+		c.Assert(err, ErrorMatches, regexp.QuoteMeta(eg.expected))
+	}
+
+	_, err := ParseLibraryFS(make(fstest.MapFS), "a.reflext")
+	c.Assert(err, ErrorMatches, "open a.reflext: file does not exist")
+	_, err = ParseLibrary("lib.reflext", strings.NewReader(`import "common.reflext"`))
+	c.Assert(err, ErrorMatches, "lib.reflext:1:8: unable to import common.reflext without a file system")
+}
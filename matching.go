@@ -13,25 +13,106 @@
 package reflext
 
 import (
+	"path"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
+// expression is a node of a compiled pattern. Match calls k for each way typ
+// matches, once the groups captured along that way are recorded, and returns
+// true as soon as k does. Returning false from k backtracks into the next way
+// to match, if any.
+//
+// When captures is nil, nothing observes the way typ matches, so nodes match
+// their sub-expressions as plain predicates and call k at most once, without
+// allocating continuations.
 type expression interface {
-	Match(reflect.Type, *[]reflect.Type) bool
+	Match(typ reflect.Type, captures *groups, k func() bool) bool
 	String() string
 }
 
+func accept() bool {
+	return true
+}
+
+// groups holds, for each group, the last type it captured, whether it
+// captured anything at all, and every type it captured in order, which differ
+// from the last one for groups under a repetition.
+type groups struct {
+	types   []reflect.Type
+	matched []bool
+	history [][]reflect.Type
+
+	// assumed counts, for each recursive pattern and type, how many times the
+	// pattern is being matched against the type.
+	assumed map[assumption]int
+}
+
+type assumption struct {
+	rec *recOf
+	typ reflect.Type
+}
+
+func newGroups(n int) *groups {
+	return &groups{
+		types:   make([]reflect.Type, n),
+		matched: make([]bool, n),
+		history: make([][]reflect.Type, n),
+	}
+}
+
+// capture records typ in the i-th group while calling k, so that groups are
+// rolled back when backtracking out of a failed branch.
+func (g *groups) capture(i int, typ reflect.Type, k func() bool) bool {
+	prevType, prevMatched := g.types[i], g.matched[i]
+	g.types[i], g.matched[i] = typ, true
+	g.history[i] = append(g.history[i], typ)
+	ok := k()
+	g.types[i], g.matched[i] = prevType, prevMatched
+	g.history[i] = g.history[i][:len(g.history[i])-1]
+	return ok
+}
+
+// precedence returns how tightly an expression binds, so that String adds
+// parentheses only where needed: unions and recursive patterns, whose body
+// extends as far right as possible, bind the loosest, then intersections, then
+// everything else.
+func precedence(exp expression) int {
+	switch exp.(type) {
+	case *firstOf, *recOf:
+		return 0
+	case *allOf:
+		return 1
+	}
+	return 2
+}
+
+func parenthesize(exp expression, prec int) string {
+	if precedence(exp) < prec {
+		return "(" + exp.String() + ")"
+	}
+	return exp.String()
+}
+
 type exact struct {
 	typ reflect.Type
 }
 
-func (m *exact) Match(typ reflect.Type, _ *[]reflect.Type) bool {
-	return m.typ == typ
+func (m *exact) Match(typ reflect.Type, _ *groups, k func() bool) bool {
+	return m.typ == typ && k()
 }
 
+// String only omits exact[...] for named types, since other types would read
+// as patterns, e.g. []error would match slices of any type implementing error.
 func (m *exact) String() string {
+	if m.typ == types["any"] {
+		return "exact[any]"
+	}
+	if m.typ.Name() == "" || m.typ.Kind() == reflect.Interface {
+		return "exact[" + m.typ.String() + "]"
+	}
 	return m.typ.String()
 }
 
@@ -39,27 +120,59 @@ type implements struct {
 	typ reflect.Type
 }
 
-func (m *implements) Match(typ reflect.Type, _ *[]reflect.Type) bool {
-	return typ.Implements(m.typ)
+func (m *implements) Match(typ reflect.Type, _ *groups, k func() bool) bool {
+	return typ.Implements(m.typ) && k()
 }
 
 func (m *implements) String() string {
+	if m.typ == types["any"] {
+		return "any"
+	}
 	return m.typ.String()
 }
 
+// implOf matches types T implementing an interface, either with the method
+// set of T or with that of *T.
+type implOf struct {
+	typ reflect.Type
+}
+
+func (m *implOf) Match(typ reflect.Type, _ *groups, k func() bool) bool {
+	if !typ.Implements(m.typ) && (typ.Kind() == reflect.Interface || !reflect.PtrTo(typ).Implements(m.typ)) {
+		return false
+	}
+	return k()
+}
+
+func (m *implOf) String() string {
+	return "impl[" + m.typ.String() + "]"
+}
+
+type assignableTo struct {
+	typ reflect.Type
+}
+
+func (m *assignableTo) Match(typ reflect.Type, _ *groups, k func() bool) bool {
+	return typ.AssignableTo(m.typ) && k()
+}
+
+func (m *assignableTo) String() string {
+	return "assignable[" + m.typ.String() + "]"
+}
+
 type sliceOf struct {
 	exp expression
 }
 
-func (m *sliceOf) Match(typ reflect.Type, captures *[]reflect.Type) bool {
+func (m *sliceOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
 	if typ.Kind() != reflect.Slice {
 		return false
 	}
-	return m.exp.Match(typ.Elem(), captures)
+	return m.exp.Match(typ.Elem(), captures, k)
 }
 
 func (m *sliceOf) String() string {
-	return "[]" + m.exp.String()
+	return "[]" + parenthesize(m.exp, 2)
 }
 
 type arrayOf struct {
@@ -67,33 +180,122 @@ type arrayOf struct {
 	exp  expression
 }
 
-func (m *arrayOf) Match(typ reflect.Type, captures *[]reflect.Type) bool {
+func (m *arrayOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
 	if typ.Kind() != reflect.Array {
 		return false
 	}
 	if m.size != typ.Len() {
 		return false
 	}
-	return m.exp.Match(typ.Elem(), captures)
+	return m.exp.Match(typ.Elem(), captures, k)
 }
 
 func (m *arrayOf) String() string {
-	return "[" + strconv.Itoa(m.size) + "]" + m.exp.String()
+	return "[" + strconv.Itoa(m.size) + "]" + parenthesize(m.exp, 2)
+}
+
+// arrayRange matches arrays whose length is between min and max, where max is
+// -1 for arrays of any length.
+type arrayRange struct {
+	min, max int
+	exp      expression
+}
+
+func (m *arrayRange) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if typ.Kind() != reflect.Array {
+		return false
+	}
+	if typ.Len() < m.min || (m.max >= 0 && m.max < typ.Len()) {
+		return false
+	}
+	return m.exp.Match(typ.Elem(), captures, k)
+}
+
+func (m *arrayRange) String() string {
+	if m.max < 0 {
+		return "[_]" + parenthesize(m.exp, 2)
+	}
+	return "[" + strconv.Itoa(m.min) + ".." + strconv.Itoa(m.max) + "]" + parenthesize(m.exp, 2)
 }
 
 type ptrOf struct {
 	exp expression
 }
 
-func (m *ptrOf) Match(typ reflect.Type, captures *[]reflect.Type) bool {
+func (m *ptrOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
 	if typ.Kind() != reflect.Ptr {
 		return false
 	}
-	return m.exp.Match(typ.Elem(), captures)
+	return m.exp.Match(typ.Elem(), captures, k)
 }
 
 func (m *ptrOf) String() string {
-	return "*" + m.exp.String()
+	return "*" + parenthesize(m.exp, 2)
+}
+
+// ptrRange matches between min and max pointers to exp, where max is -1 for
+// any number of pointers. Deeper pointers are tried first.
+type ptrRange struct {
+	min, max int
+	exp      expression
+}
+
+func (m *ptrRange) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	for depth := pointers(typ, m.max); m.min <= depth; depth-- {
+		elem := strip(typ, depth)
+		if captures == nil {
+			if m.exp.Match(elem, nil, accept) {
+				return k()
+			}
+		} else if m.exp.Match(elem, captures, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ptrRange) String() string {
+	if m.max < 0 {
+		return "*+" + parenthesize(m.exp, 2)
+	}
+	return "*?" + parenthesize(m.exp, 2)
+}
+
+// pointers returns how many pointers can be stripped from typ, at most max
+// unless it is -1. Pointer types may be cyclic, as with type P *P, in which
+// case stripping stops at the first type seen twice.
+func pointers(typ reflect.Type, max int) int {
+	depth := 0
+	for elem := typ; elem.Kind() == reflect.Ptr && (max < 0 || depth < max); depth++ {
+		next := elem.Elem()
+		for i, seen := 0, typ; i <= depth; i, seen = i+1, seen.Elem() {
+			if seen == next {
+				return depth + 1
+			}
+		}
+		elem = next
+	}
+	return depth
+}
+
+func strip(typ reflect.Type, depth int) reflect.Type {
+	for i := 0; i < depth; i++ {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// derefOf strips all pointers, then matches exp.
+type derefOf struct {
+	exp expression
+}
+
+func (m *derefOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	return m.exp.Match(strip(typ, pointers(typ, -1)), captures, k)
+}
+
+func (m *derefOf) String() string {
+	return "deref[" + m.exp.String() + "]"
 }
 
 type mapOf struct {
@@ -101,21 +303,20 @@ type mapOf struct {
 	value expression
 }
 
-func (m *mapOf) Match(typ reflect.Type, captures *[]reflect.Type) bool {
+func (m *mapOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
 	if typ.Kind() != reflect.Map {
 		return false
 	}
-	if !m.key.Match(typ.Key(), captures) {
-		return false
-	}
-	if !m.value.Match(typ.Elem(), captures) {
-		return false
+	if captures == nil {
+		return m.key.Match(typ.Key(), nil, accept) && m.value.Match(typ.Elem(), nil, k)
 	}
-	return true
+	return m.key.Match(typ.Key(), captures, func() bool {
+		return m.value.Match(typ.Elem(), captures, k)
+	})
 }
 
 func (m *mapOf) String() string {
-	return "map[" + m.key.String() + "]" + m.value.String()
+	return "map[" + m.key.String() + "]" + parenthesize(m.value, 2)
 }
 
 type chanOf struct {
@@ -123,24 +324,26 @@ type chanOf struct {
 	dir reflect.ChanDir
 }
 
-func (m *chanOf) Match(typ reflect.Type, captures *[]reflect.Type) bool {
+func (m *chanOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
 	if typ.Kind() != reflect.Chan {
 		return false
 	}
-	if typ.ChanDir() != m.dir {
+	if m.dir != 0 && typ.ChanDir() != m.dir {
 		return false
 	}
-	return m.exp.Match(typ.Elem(), captures)
+	return m.exp.Match(typ.Elem(), captures, k)
 }
 
 func (m *chanOf) String() string {
 	switch m.dir {
 	case reflect.BothDir:
-		return "chan " + m.exp.String()
+		return "chan " + parenthesize(m.exp, 2)
 	case reflect.SendDir:
-		return "chan<- " + m.exp.String()
+		return "chan<- " + parenthesize(m.exp, 2)
 	case reflect.RecvDir:
-		return "<-chan " + m.exp.String()
+		return "<-chan " + parenthesize(m.exp, 2)
+	case 0:
+		return "chan? " + parenthesize(m.exp, 2)
 	}
 	panic("unreachable")
 }
@@ -150,27 +353,100 @@ type funcOf struct {
 	returns   []expression
 }
 
-func (m *funcOf) Match(typ reflect.Type, captures *[]reflect.Type) bool {
+func (m *funcOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
 	if typ.Kind() != reflect.Func {
 		return false
 	}
-	if len(m.arguments) != typ.NumIn() {
+	if m.variadic() != typ.IsVariadic() {
 		return false
 	}
-	if len(m.returns) != typ.NumOut() {
-		return false
+	if captures == nil {
+		return matchTypes(m.arguments, typ, false, 0, typ.NumIn()) &&
+			matchTypes(m.returns, typ, true, 0, typ.NumOut()) && k()
 	}
-	for i, a := range m.arguments {
-		if !a.Match(typ.In(i), captures) {
-			return false
+	return m.matchCaptures(typ, captures, k)
+}
+
+func (m *funcOf) matchCaptures(typ reflect.Type, captures *groups, k func() bool) bool {
+	arguments, numIn := m.arguments, typ.NumIn()
+	returns := func() bool {
+		return matchList(m.returns, typ.Out, 0, typ.NumOut(), captures, k)
+	}
+	if !m.variadic() {
+		return matchList(arguments, typ.In, 0, numIn, captures, returns)
+	}
+	arguments, numIn = arguments[:len(arguments)-1], numIn-1
+	return matchList(arguments, typ.In, 0, numIn, captures, func() bool {
+		return m.arguments[len(arguments)].Match(typ.In(numIn), captures, returns)
+	})
+}
+
+// matchList matches exps against the types at(i) to at(n-1), backtracking
+// over the number of types consumed by each repeatOf.
+func matchList(exps []expression, at func(int) reflect.Type, i, n int, captures *groups, k func() bool) bool {
+	if len(exps) == 0 {
+		return i == n && k()
+	}
+	if r, ok := exps[0].(*repeatOf); ok {
+		return matchRepeat(r, 0, exps[1:], at, i, n, captures, k)
+	}
+	return i < n && exps[0].Match(at(i), captures, func() bool {
+		return matchList(exps[1:], at, i+1, n, captures, k)
+	})
+}
+
+// matchRepeat greedily matches one more repetition of r, having matched count
+// so far, before falling back to matching the rest of the list.
+func matchRepeat(r *repeatOf, count int, rest []expression, at func(int) reflect.Type, i, n int, captures *groups, k func() bool) bool {
+	if i < n && (r.max < 0 || count < r.max) {
+		if r.exp.Match(at(i), captures, func() bool {
+			return matchRepeat(r, count+1, rest, at, i+1, n, captures, k)
+		}) {
+			return true
 		}
 	}
-	for i, r := range m.returns {
-		if !r.Match(typ.Out(i), captures) {
-			return false
+	return r.min <= count && matchList(rest, at, i, n, captures, k)
+}
+
+// matchTypes is matchList without captures, matching exps against the
+// arguments, or the results when out is set, from i to n-1. Types are then
+// matched independently of each other, so only the number of types consumed
+// by each repeatOf is backtracked over.
+func matchTypes(exps []expression, typ reflect.Type, out bool, i, n int) bool {
+	if len(exps) == 0 {
+		return i == n
+	}
+	switch exp := exps[0].(type) {
+	case *repeatOf:
+		j := i
+		for j < n && (exp.max < 0 || j-i < exp.max) && exp.exp.Match(typeAt(typ, out, j), nil, accept) {
+			j++
+		}
+		for ; exp.min <= j-i; j-- {
+			if matchTypes(exps[1:], typ, out, j, n) {
+				return true
+			}
 		}
+		return false
+	case *variadicOf:
+		return i == n-1 && exp.Match(typeAt(typ, out, i), nil, accept)
 	}
-	return true
+	return i < n && exps[0].Match(typeAt(typ, out, i), nil, accept) && matchTypes(exps[1:], typ, out, i+1, n)
+}
+
+func typeAt(typ reflect.Type, out bool, i int) reflect.Type {
+	if out {
+		return typ.Out(i)
+	}
+	return typ.In(i)
+}
+
+func (m *funcOf) variadic() bool {
+	if len(m.arguments) == 0 {
+		return false
+	}
+	_, ok := m.arguments[len(m.arguments)-1].(*variadicOf)
+	return ok
 }
 
 func (m *funcOf) String() string {
@@ -182,9 +458,15 @@ func (m *funcOf) String() string {
 	for _, a := range m.arguments {
 		args = append(args, a.String())
 	}
-	if len(m.returns) == 1 {
+	switch len(m.returns) {
+	case 0:
+		return "func(" + strings.Join(args, ", ") + ")"
+	case 1:
 		ret = m.returns[0].String()
-	} else {
+		if precedence(m.returns[0]) < 2 || strings.HasPrefix(ret, "(") {
+			ret = "(" + ret + ")"
+		}
+	default:
 		for _, r := range m.returns {
 			rets = append(rets, r.String())
 		}
@@ -193,49 +475,498 @@ func (m *funcOf) String() string {
 	return "func(" + strings.Join(args, ", ") + ") " + ret
 }
 
+type variadicOf struct {
+	exp expression
+}
+
+func (m *variadicOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if typ.Kind() != reflect.Slice {
+		return false
+	}
+	return m.exp.Match(typ.Elem(), captures, k)
+}
+
+func (m *variadicOf) String() string {
+	return "..." + m.exp.String()
+}
+
+type repeatOf struct {
+	exp      expression
+	min, max int
+}
+
+func (m *repeatOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	return m.min <= 1 && m.max != 0 && m.exp.Match(typ, captures, k)
+}
+
+func (m *repeatOf) String() string {
+	exp := parenthesize(m.exp, 2)
+	if _, ok := m.exp.(*funcOf); ok {
+		exp = "(" + exp + ")"
+	}
+	switch {
+	case m.min == 0 && m.max < 0:
+		return exp + "*"
+	case m.min == 1 && m.max < 0:
+		return exp + "+"
+	case m.min == 0 && m.max == 1:
+		return exp + "?"
+	case m.max < 0:
+		return exp + "{" + strconv.Itoa(m.min) + ",}"
+	case m.min == m.max:
+		return exp + "{" + strconv.Itoa(m.min) + "}"
+	}
+	return exp + "{" + strconv.Itoa(m.min) + "," + strconv.Itoa(m.max) + "}"
+}
+
+type tag struct {
+	key   string
+	value string
+}
+
+func (t *tag) Match(st reflect.StructTag) bool {
+	value, ok := st.Lookup(t.key)
+	switch {
+	case !ok:
+		return false
+	case t.value == "*":
+		return true
+	case strings.HasSuffix(t.value, "*"):
+		return strings.HasPrefix(value, t.value[:len(t.value)-1])
+	}
+	return value == t.value
+}
+
+func (t *tag) String() string {
+	if t.value == "*" {
+		return t.key
+	}
+	return t.key + ":" + strconv.Quote(t.value)
+}
+
+type field struct {
+	name string
+	exp  expression
+	tags []tag
+}
+
+func (f *field) Match(sf reflect.StructField, captures *groups, k func() bool) bool {
+	if f.name != "_" && f.name != sf.Name {
+		return false
+	}
+	for _, t := range f.tags {
+		if !t.Match(sf.Tag) {
+			return false
+		}
+	}
+	return f.exp.Match(sf.Type, captures, k)
+}
+
+func (f *field) String() string {
+	if len(f.tags) == 0 {
+		return f.name + " " + f.exp.String()
+	}
+	var tags []string
+	for _, t := range f.tags {
+		tags = append(tags, t.String())
+	}
+	return f.name + " " + f.exp.String() + " `" + strings.Join(tags, " ") + "`"
+}
+
+type structOf struct {
+	fields []field
+	open   bool
+}
+
+func (m *structOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if typ.Kind() != reflect.Struct {
+		return false
+	}
+	if !m.open && len(m.fields) != typ.NumField() {
+		return false
+	}
+	if captures == nil {
+		var buf [16]bool
+		used := buf[:]
+		if len(used) < typ.NumField() {
+			used = make([]bool, typ.NumField())
+		}
+		return m.assignFields(typ, 0, used[:typ.NumField()]) && k()
+	}
+	return m.matchFields(typ, 0, make([]bool, typ.NumField()), captures, k)
+}
+
+// assignFields is matchFields without captures.
+func (m *structOf) assignFields(typ reflect.Type, i int, used []bool) bool {
+	if i == len(m.fields) {
+		return true
+	}
+	for j := range used {
+		if used[j] {
+			continue
+		}
+		used[j] = true
+		if m.fields[i].Match(typ.Field(j), nil, accept) && m.assignFields(typ, i+1, used) {
+			return true
+		}
+		used[j] = false
+	}
+	return false
+}
+
+// matchFields assigns a distinct struct field to each of the fields from i
+// onwards, backtracking when wildcard names leave more than one candidate.
+func (m *structOf) matchFields(typ reflect.Type, i int, used []bool, captures *groups, k func() bool) bool {
+	if i == len(m.fields) {
+		return k()
+	}
+	for j := range used {
+		if used[j] {
+			continue
+		}
+		used[j] = true
+		if m.fields[i].Match(typ.Field(j), captures, func() bool {
+			return m.matchFields(typ, i+1, used, captures, k)
+		}) {
+			return true
+		}
+		used[j] = false
+	}
+	return false
+}
+
+func (m *structOf) String() string {
+	var fields []string
+	for _, f := range m.fields {
+		fields = append(fields, f.String())
+	}
+	if m.open {
+		fields = append(fields, "...")
+	}
+	if len(fields) == 0 {
+		return "struct{}"
+	}
+	return "struct{ " + strings.Join(fields, "; ") + " }"
+}
+
+type method struct {
+	name string
+	sig  *funcOf
+}
+
+func (m *method) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	mt, ok := typ.MethodByName(m.name)
+	if !ok {
+		return false
+	}
+	if typ.Kind() == reflect.Interface {
+		return m.sig.Match(mt.Type, captures, k)
+	}
+	return m.sig.Match(dropReceiver(mt.Type), captures, k)
+}
+
+func matchMethods(methods []method, typ reflect.Type, captures *groups, k func() bool) bool {
+	if captures == nil {
+		for i := range methods {
+			if !methods[i].Match(typ, nil, accept) {
+				return false
+			}
+		}
+		return k()
+	}
+	if len(methods) == 0 {
+		return k()
+	}
+	return methods[0].Match(typ, captures, func() bool {
+		return matchMethods(methods[1:], typ, captures, k)
+	})
+}
+
+func (m *method) String() string {
+	return m.name + strings.TrimPrefix(m.sig.String(), "func")
+}
+
+func dropReceiver(typ reflect.Type) reflect.Type {
+	in := make([]reflect.Type, typ.NumIn()-1)
+	for i := range in {
+		in[i] = typ.In(i + 1)
+	}
+	out := make([]reflect.Type, typ.NumOut())
+	for i := range out {
+		out[i] = typ.Out(i)
+	}
+	return reflect.FuncOf(in, out, typ.IsVariadic())
+}
+
+type interfaceOf struct {
+	methods []method
+	open    bool
+}
+
+func (m *interfaceOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if !m.open && (typ.Kind() != reflect.Interface || typ.NumMethod() != len(m.methods)) {
+		return false
+	}
+	return matchMethods(m.methods, typ, captures, k)
+}
+
+func (m *interfaceOf) String() string {
+	var methods []string
+	for _, method := range m.methods {
+		methods = append(methods, method.String())
+	}
+	if m.open {
+		methods = append(methods, "...")
+	}
+	if len(methods) == 0 {
+		return "interface{}"
+	}
+	return "interface{ " + strings.Join(methods, "; ") + " }"
+}
+
+type hasMethods struct {
+	methods []method
+	ptr     bool
+}
+
+func (m *hasMethods) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if m.ptr && typ.Kind() != reflect.Ptr && typ.Kind() != reflect.Interface {
+		typ = reflect.PtrTo(typ)
+	}
+	return matchMethods(m.methods, typ, captures, k)
+}
+
+func (m *hasMethods) String() string {
+	var methods []string
+	for _, method := range m.methods {
+		methods = append(methods, method.String())
+	}
+	if m.ptr {
+		return "has*[" + strings.Join(methods, ", ") + "]"
+	}
+	return "has[" + strings.Join(methods, ", ") + "]"
+}
+
 type kindOf struct {
 	kind reflect.Kind
 }
 
-func (m *kindOf) Match(typ reflect.Type, captures *[]reflect.Type) bool {
-	return m.kind == typ.Kind()
+func (m *kindOf) Match(typ reflect.Type, _ *groups, k func() bool) bool {
+	return m.kind == typ.Kind() && k()
 }
 
 func (m *kindOf) String() string {
 	return "kind[" + m.kind.String() + "]"
 }
 
+// classOf matches types whose kind belongs to a class of kinds, such as the
+// signed integers.
+type classOf struct {
+	name string
+}
+
+func (m *classOf) Match(typ reflect.Type, _ *groups, k func() bool) bool {
+	for _, kind := range classes[m.name] {
+		if kind == typ.Kind() {
+			return k()
+		}
+	}
+	return false
+}
+
+func (m *classOf) String() string {
+	return m.name
+}
+
+// isComparable matches types whose values can be compared with ==, which are
+// the types allowed as map keys.
+type isComparable struct{}
+
+func (m *isComparable) Match(typ reflect.Type, _ *groups, k func() bool) bool {
+	return typ.Comparable() && k()
+}
+
+func (m *isComparable) String() string {
+	return "comparable"
+}
+
 type aliasOf struct {
 	exp expression
 }
 
-func (m *aliasOf) Match(typ reflect.Type, captures *[]reflect.Type) bool {
+func (m *aliasOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
 	if typ.Name() == "" {
 		return false
 	}
-	return m.exp.Match(typ, captures)
+	return m.exp.Match(typ, captures, k)
 }
 
 func (m *aliasOf) String() string {
 	return "alias[" + m.exp.String() + "]"
 }
 
+type namedOf struct {
+	exp expression
+}
+
+func (m *namedOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if typ.Name() == "" {
+		return false
+	}
+	return m.exp.Match(typ, captures, k)
+}
+
+func (m *namedOf) String() string {
+	return "named[" + m.exp.String() + "]"
+}
+
+type unnamedOf struct {
+	exp expression
+}
+
+func (m *unnamedOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if typ.Name() != "" {
+		return false
+	}
+	return m.exp.Match(typ, captures, k)
+}
+
+func (m *unnamedOf) String() string {
+	return "unnamed[" + m.exp.String() + "]"
+}
+
+type nameMatches struct {
+	re *regexp.Regexp
+}
+
+func (m *nameMatches) Match(typ reflect.Type, _ *groups, k func() bool) bool {
+	return m.re.MatchString(typ.Name()) && k()
+}
+
+func (m *nameMatches) String() string {
+	return "/" + m.re.String() + "/"
+}
+
+// pkgOf matches the package path of types against a glob, as in path.Match.
+// A glob ending in "/..." also matches all packages below it, the way the go
+// tool does.
+type pkgOf struct {
+	glob string
+}
+
+func (m *pkgOf) Match(typ reflect.Type, _ *groups, k func() bool) bool {
+	return m.matchPkgPath(typ.PkgPath()) && k()
+}
+
+func (m *pkgOf) matchPkgPath(pkgPath string) bool {
+	if prefix := strings.TrimSuffix(m.glob, "/..."); prefix != m.glob {
+		if ok, _ := path.Match(prefix, pkgPath); ok {
+			return true
+		}
+		for dir := path.Dir(pkgPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if ok, _ := path.Match(prefix, dir); ok {
+				return true
+			}
+		}
+		return false
+	}
+	ok, _ := path.Match(m.glob, pkgPath)
+	return ok
+}
+
+func (m *pkgOf) String() string {
+	return "pkg[" + m.glob + "]"
+}
+
+// convertibleTo matches named types other than typ which can be converted to
+// typ.
 type convertibleTo struct {
 	typ reflect.Type
 }
 
-func (m *convertibleTo) Match(typ reflect.Type, captures *[]reflect.Type) bool {
-	return m.typ != typ && typ.ConvertibleTo(m.typ)
+func (m *convertibleTo) Match(typ reflect.Type, _ *groups, k func() bool) bool {
+	return typ.Name() != "" && m.typ != typ && typ.ConvertibleTo(m.typ) && k()
 }
 
 func (m *convertibleTo) String() string {
+	return "convertible[" + m.typ.String() + "]"
+}
+
+// underlyingOf matches types other than typ whose underlying type is that of
+// typ. Predeclared types, which are their own underlying type, never match.
+type underlyingOf struct {
+	typ reflect.Type
+}
+
+func (m *underlyingOf) Match(typ reflect.Type, _ *groups, k func() bool) bool {
+	if typ.PkgPath() == "" {
+		return false
+	}
+	return m.typ != typ && identicalUnderlying(m.typ, typ) && k()
+}
+
+func (m *underlyingOf) String() string {
 	return m.typ.String()
 }
 
+// identicalUnderlying tells whether a and b have identical underlying types.
+// Since reflect does not expose underlying types, their structure is compared
+// instead, down to the types they are composed of, which must be identical.
+func identicalUnderlying(a, b reflect.Type) bool {
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Array:
+		return a.Len() == b.Len() && a.Elem() == b.Elem()
+	case reflect.Chan:
+		return a.ChanDir() == b.ChanDir() && a.Elem() == b.Elem()
+	case reflect.Ptr, reflect.Slice:
+		return a.Elem() == b.Elem()
+	case reflect.Map:
+		return a.Key() == b.Key() && a.Elem() == b.Elem()
+	case reflect.Func:
+		if a.NumIn() != b.NumIn() || a.NumOut() != b.NumOut() || a.IsVariadic() != b.IsVariadic() {
+			return false
+		}
+		for i := 0; i < a.NumIn(); i++ {
+			if a.In(i) != b.In(i) {
+				return false
+			}
+		}
+		for i := 0; i < a.NumOut(); i++ {
+			if a.Out(i) != b.Out(i) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		if a.NumField() != b.NumField() {
+			return false
+		}
+		for i := 0; i < a.NumField(); i++ {
+			fa, fb := a.Field(i), b.Field(i)
+			if fa.Name != fb.Name || fa.PkgPath != fb.PkgPath || fa.Type != fb.Type || fa.Tag != fb.Tag || fa.Anonymous != fb.Anonymous {
+				return false
+			}
+		}
+	case reflect.Interface:
+		if a.NumMethod() != b.NumMethod() {
+			return false
+		}
+		for i := 0; i < a.NumMethod(); i++ {
+			ma, mb := a.Method(i), b.Method(i)
+			if ma.Name != mb.Name || ma.PkgPath != mb.PkgPath || ma.Type != mb.Type {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 type any struct{}
 
-func (m *any) Match(_ reflect.Type, _ *[]reflect.Type) bool {
-	return true
+func (m *any) Match(_ reflect.Type, _ *groups, k func() bool) bool {
+	return k()
 }
 
 func (m *any) String() string {
@@ -246,9 +977,13 @@ type firstOf struct {
 	exps []expression
 }
 
-func (m *firstOf) Match(typ reflect.Type, captures *[]reflect.Type) bool {
+func (m *firstOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
 	for _, exp := range m.exps {
-		if exp.Match(typ, captures) {
+		if captures == nil {
+			if exp.Match(typ, nil, accept) {
+				return k()
+			}
+		} else if exp.Match(typ, captures, k) {
 			return true
 		}
 	}
@@ -258,43 +993,179 @@ func (m *firstOf) Match(typ reflect.Type, captures *[]reflect.Type) bool {
 func (m *firstOf) String() string {
 	var e []string
 	for _, exp := range m.exps {
-		e = append(e, exp.String())
+		e = append(e, parenthesize(exp, 1))
 	}
 	return strings.Join(e, " | ")
 }
 
+type allOf struct {
+	exps []expression
+}
+
+func (m *allOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if captures == nil {
+		for _, exp := range m.exps {
+			if !exp.Match(typ, nil, accept) {
+				return false
+			}
+		}
+		return k()
+	}
+	return matchAll(m.exps, typ, captures, k)
+}
+
+func matchAll(exps []expression, typ reflect.Type, captures *groups, k func() bool) bool {
+	if len(exps) == 0 {
+		return k()
+	}
+	return exps[0].Match(typ, captures, func() bool {
+		return matchAll(exps[1:], typ, captures, k)
+	})
+}
+
+func (m *allOf) String() string {
+	var e []string
+	for _, exp := range m.exps {
+		e = append(e, parenthesize(exp, 2))
+	}
+	return strings.Join(e, " & ")
+}
+
+type notOf struct {
+	exp expression
+}
+
+func (m *notOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	return !m.exp.Match(typ, captures, accept) && k()
+}
+
+func (m *notOf) String() string {
+	return "!" + parenthesize(m.exp, 2)
+}
+
 type captureOf struct {
 	exp   expression
 	index int
+	name  string
 }
 
-func (m *captureOf) Match(typ reflect.Type, captures *[]reflect.Type) bool {
-	if ok := m.exp.Match(typ, captures); !ok {
-		return false
+func (m *captureOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if captures == nil {
+		return m.exp.Match(typ, nil, k)
 	}
-	if captures != nil {
-		(*captures)[m.index] = typ
-	}
-	return true
+	return m.exp.Match(typ, captures, func() bool {
+		return captures.capture(m.index, typ, k)
+	})
 }
 
 func (m *captureOf) String() string {
+	if m.name != "" {
+		return "{" + m.name + ": " + m.exp.String() + "}"
+	}
 	return "{" + m.exp.String() + "}"
 }
 
+type backrefOf struct {
+	index int
+	name  string
+}
+
+func (m *backrefOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if captures == nil || !captures.matched[m.index] {
+		return false
+	}
+	return captures.types[m.index] == typ && k()
+}
+
+func (m *backrefOf) String() string {
+	if m.name != "" {
+		return "\\" + m.name
+	}
+	return "\\" + strconv.Itoa(m.index+1)
+}
+
+// recOf is a recursive pattern, whose variable stands for the pattern itself.
+// Matching is coinductive: a variable matches a type the pattern is already
+// being matched against, so that matching terminates on recursive types.
+type recOf struct {
+	name string
+	exp  expression
+}
+
+func (m *recOf) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if captures == nil {
+		// Only patterns without groups are matched without captures.
+		captures = newGroups(0)
+	}
+	if captures.assumed == nil {
+		captures.assumed = make(map[assumption]int)
+	}
+	a := assumption{m, typ}
+	captures.assumed[a]++
+	ok := m.exp.Match(typ, captures, func() bool {
+		captures.assumed[a]--
+		ok := k()
+		captures.assumed[a]++
+		return ok
+	})
+	captures.assumed[a]--
+	return ok
+}
+
+func (m *recOf) String() string {
+	return "rec " + m.name + ". " + m.exp.String()
+}
+
+type recVar struct {
+	rec *recOf
+}
+
+func (m *recVar) Match(typ reflect.Type, captures *groups, k func() bool) bool {
+	if captures.assumed[assumption{m.rec, typ}] > 0 {
+		return k()
+	}
+	return m.rec.Match(typ, captures, k)
+}
+
+func (m *recVar) String() string {
+	return m.rec.name
+}
+
 // Assert that all matches implement the expression interface.
 var _ = []expression{
 	&exact{},
 	&implements{},
+	&implOf{},
+	&assignableTo{},
 	&sliceOf{},
 	&arrayOf{},
+	&arrayRange{},
+	&ptrRange{},
+	&derefOf{},
 	&ptrOf{},
 	&mapOf{},
 	&funcOf{},
+	&variadicOf{},
+	&repeatOf{},
+	&structOf{},
+	&interfaceOf{},
+	&hasMethods{},
 	&kindOf{},
+	&classOf{},
+	&isComparable{},
 	&aliasOf{},
+	&namedOf{},
+	&unnamedOf{},
+	&nameMatches{},
+	&pkgOf{},
 	&convertibleTo{},
+	&underlyingOf{},
 	&any{},
 	&firstOf{},
+	&allOf{},
+	&notOf{},
 	&captureOf{},
+	&backrefOf{},
+	&recOf{},
+	&recVar{},
 }
@@ -14,10 +14,14 @@ package reflext
 
 import (
 	"fmt"
+	"path"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"text/scanner"
+	"unicode"
+	"unsafe"
 )
 
 var types = map[string]reflect.Type{
@@ -41,6 +45,9 @@ var types = map[string]reflect.Type{
 	"uint32":     reflect.TypeOf(uint32(0)),
 	"uint64":     reflect.TypeOf(uint64(0)),
 	"uintptr":    reflect.TypeOf(uintptr(0)),
+
+	"any":            reflect.TypeOf((*interface{})(nil)).Elem(),
+	"unsafe.Pointer": reflect.TypeOf(unsafe.Pointer(nil)),
 }
 
 var kinds = map[string]reflect.Kind{
@@ -69,6 +76,51 @@ var kinds = map[string]reflect.Kind{
 	"slice":      reflect.Slice,
 	"string":     reflect.String,
 	"struct":     reflect.Struct,
+
+	"unsafe.Pointer": reflect.UnsafePointer,
+}
+
+var classes = map[string][]reflect.Kind{
+	"signed":   {reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64},
+	"unsigned": {reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr},
+	"numeric": {
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
+	},
+	"ordered": {
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.String,
+	},
+}
+
+// keywords are parsed before identifiers are looked up in the universe, so
+// they cannot be bound.
+var keywords = map[string]bool{
+	"_":           true,
+	"alias":       true,
+	"assignable":  true,
+	"chan":        true,
+	"comparable":  true,
+	"convertible": true,
+	"deref":       true,
+	"exact":       true,
+	"func":        true,
+	"has":         true,
+	"impl":        true,
+	"interface":   true,
+	"kind":        true,
+	"map":         true,
+	"named":       true,
+	"numeric":     true,
+	"ordered":     true,
+	"pkg":         true,
+	"rec":         true,
+	"signed":      true,
+	"struct":      true,
+	"unnamed":     true,
+	"unsigned":    true,
 }
 
 var stop = map[string]bool{
@@ -76,11 +128,17 @@ var stop = map[string]bool{
 	")": true,
 	",": true,
 	"|": true,
+	"]": true,
+	"}": true,
+	"?": true,
+	"+": true,
+	";": true,
+	"&": true,
 }
 
 type token struct {
-	text   string
-	offset int
+	text string
+	pos  scanner.Position
 }
 
 type parser struct {
@@ -89,31 +147,115 @@ type parser struct {
 
 	args      []interface{}
 	argsIndex int
+	used      []bool
+
+	names     []string
+	backrefs  bool
+	recursive bool
 
-	group int
+	universe *Universe
+
+	// lib holds the definitions referred to by @Name, params the arguments of
+	// the definition being expanded and the variables of enclosing recursive
+	// patterns, base the index of the first group of the definition, and
+	// expanding the definitions being expanded, outermost first. instances
+	// holds the definitions already parsed without groups.
+	lib       *Library
+	params    map[string]expression
+	base      int
+	expanding []string
+	instances map[*definition][]instance
+
+	err error
 }
 
-func parse(expr string, args ...interface{}) (expression, int, error) {
+func parse(expr string, args ...interface{}) (expression, []string, error) {
 	p := &parser{
 		tokens: tokenize(expr),
 		index:  0,
 		args:   args,
 	}
+	return p.parse(expr)
+}
+
+func (p *parser) parse(expr string) (expression, []string, error) {
 	exp, ok := p.parseExp()
+	if ok && p.index == len(p.tokens) {
+		ok = p.checkArgs()
+	}
+	if p.err != nil {
+		return nil, nil, p.err
+	}
 	if !ok || p.index != len(p.tokens) {
-		return nil, 0, fmt.Errorf("unable to parse %s", expr)
+		return nil, nil, fmt.Errorf("unable to parse %s", expr)
 	}
-	return exp, p.group, nil
+	return exp, p.names, nil
 }
 
-func (p *parser) parseExpList() ([]expression, bool) {
+func (p *parser) compile(expr string) (*Reflext, error) {
+	exp, names, err := p.parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Reflext{exp, names, p.backrefs, p.recursive, &definition{
+		body:     p.tokens,
+		args:     p.args,
+		lib:      p.lib,
+		universe: p.universe,
+	}}, nil
+}
+
+// checkArgs reports arguments which no verb refers to.
+func (p *parser) checkArgs() bool {
+	for i := range p.args {
+		if p.used == nil || !p.used[i] {
+			p.fail("argument %d is not used", i+1)
+			return false
+		}
+	}
+	return true
+}
+
+// fail records a more helpful error than the generic one reported by parse,
+// located at the last token read.
+func (p *parser) fail(format string, args ...interface{}) (expression, bool) {
+	return p.failAt(p.index-1, format, args...)
+}
+
+// failAt records an error located at the i-th token.
+func (p *parser) failAt(i int, format string, args ...interface{}) (expression, bool) {
+	if p.err == nil {
+		var pos scanner.Position
+		if len(p.tokens) != 0 {
+			if i < 0 {
+				i = 0
+			} else if len(p.tokens) <= i {
+				i = len(p.tokens) - 1
+			}
+			pos = p.tokens[i].pos
+		}
+		p.err = errorAt(pos, format, args...)
+	}
+	return nil, false
+}
+
+// errorAt prefixes errors with their location when tokens come from a file.
+func errorAt(pos scanner.Position, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	if pos.Filename != "" {
+		return fmt.Errorf("%s: %v", pos, err)
+	}
+	return err
+}
+
+func (p *parser) parseExpList(parseElem func() (expression, bool)) ([]expression, bool) {
 	var exps []expression
 	if text, ok := p.peek(); !ok {
 		return nil, false
 	} else if stop[text] {
 		return exps, true
 	}
-	exp, ok := p.parseExp()
+	exp, ok := parseElem()
 	if !ok {
 		return nil, false
 	}
@@ -124,7 +266,7 @@ func (p *parser) parseExpList() ([]expression, bool) {
 			done = true
 		} else if text == "," {
 			p.next()
-			exp, ok := p.parseExp()
+			exp, ok := parseElem()
 			if !ok {
 				return nil, false
 			}
@@ -136,9 +278,85 @@ func (p *parser) parseExpList() ([]expression, bool) {
 	return exps, true
 }
 
+func (p *parser) parseArgList() ([]expression, bool) {
+	exps, ok := p.parseExpList(p.parseArg)
+	if !ok {
+		return nil, false
+	}
+	for i, exp := range exps {
+		if _, ok := exp.(*variadicOf); ok && i != len(exps)-1 {
+			return nil, false
+		}
+	}
+	return exps, true
+}
+
+func (p *parser) parseArg() (expression, bool) {
+	if text, _ := p.peek(); text != "." {
+		return p.parseRepeatedExp()
+	}
+	for i := 0; i < 3; i++ {
+		if !p.consume(".") {
+			return nil, false
+		}
+	}
+	exp, ok := p.parseExp()
+	if !ok {
+		return nil, false
+	}
+	return &variadicOf{exp}, true
+}
+
+func (p *parser) parseRepeatedExp() (expression, bool) {
+	exp, ok := p.parseExp()
+	if !ok {
+		return nil, false
+	}
+	return p.parseRepeat(exp)
+}
+
+func (p *parser) parseRepeat(exp expression) (expression, bool) {
+	text, _ := p.peek()
+	switch text {
+	case "*":
+		p.next()
+		return &repeatOf{exp, 0, -1}, true
+	case "+":
+		p.next()
+		return &repeatOf{exp, 1, -1}, true
+	case "?":
+		p.next()
+		return &repeatOf{exp, 0, 1}, true
+	case "{":
+		if _, err := strconv.Atoi(p.lookahead(1)); err != nil {
+			return exp, true
+		}
+		p.next()
+		min, ok := p.parseInt()
+		if !ok {
+			return nil, false
+		}
+		max := min
+		if text, _ := p.peek(); text == "," {
+			p.next()
+			max = -1
+			if text, _ := p.peek(); text != "}" {
+				if max, ok = p.parseInt(); !ok || max < min {
+					return nil, false
+				}
+			}
+		}
+		if !p.consume("}") {
+			return nil, false
+		}
+		return &repeatOf{exp, min, max}, true
+	}
+	return exp, true
+}
+
 func (p *parser) parseExp() (expression, bool) {
 	var exps []expression
-	exp, ok := p.parseSubExp()
+	exp, ok := p.parseConjunction()
 	if !ok {
 		return nil, false
 	}
@@ -149,7 +367,7 @@ func (p *parser) parseExp() (expression, bool) {
 			done = true
 		} else if text == "|" {
 			p.next()
-			exp, ok := p.parseSubExp()
+			exp, ok := p.parseConjunction()
 			if !ok {
 				return nil, false
 			}
@@ -165,6 +383,30 @@ func (p *parser) parseExp() (expression, bool) {
 	}
 }
 
+func (p *parser) parseConjunction() (expression, bool) {
+	var exps []expression
+	exp, ok := p.parseSubExp()
+	if !ok {
+		return nil, false
+	}
+	exps = append(exps, exp)
+	for {
+		if text, _ := p.peek(); text != "&" {
+			break
+		}
+		p.next()
+		exp, ok := p.parseSubExp()
+		if !ok {
+			return nil, false
+		}
+		exps = append(exps, exp)
+	}
+	if len(exps) == 1 {
+		return exps[0], true
+	}
+	return &allOf{exps}, true
+}
+
 func (p *parser) parseSubExp() (expression, bool) {
 	text, ok := p.next()
 	if !ok {
@@ -173,41 +415,119 @@ func (p *parser) parseSubExp() (expression, bool) {
 	switch text {
 
 	case "[":
-		text, ok := p.next()
-		if !ok {
-			return nil, false
-		}
-		if text == "]" {
-			exp, ok := p.parseExp()
+		if text, _ := p.peek(); text == "]" {
+			p.next()
+			exp, ok := p.parseSubExp()
 			if !ok {
 				return nil, false
 			}
 			return &sliceOf{exp}, true
-		} else if size, err := strconv.ParseInt(text, 10, 32); err == nil {
-			if !p.consume("]") {
+		}
+		var (
+			capture  bool
+			anyLen   bool
+			group    int
+			name     string
+			min, max = 0, -1
+		)
+		if text, _ := p.peek(); text == "{" {
+			p.next()
+			capture = true
+			text, _ := p.peek()
+			named := isIdent(text) && text != "_"
+			if named && p.lookahead(1) == ":" {
+				name = text
+				p.next()
+				p.next()
+			} else if named && p.lookahead(1) == "}" {
+				// [{n}]E is short for [{n: _}]E.
+				name, anyLen = text, true
+				p.next()
+			}
+			var ok bool
+			if group, ok = p.group(name); !ok {
 				return nil, false
 			}
-			exp, ok := p.parseExp()
-			if !ok {
+		}
+		if !anyLen {
+			var ok bool
+			if min, max, ok = p.parseLength(); !ok {
 				return nil, false
 			}
-			return &arrayOf{int(size), exp}, true
 		}
-		return nil, false
+		if capture && !p.consume("}") {
+			return nil, false
+		}
+		if !p.consume("]") {
+			return nil, false
+		}
+		exp, ok := p.parseSubExp()
+		if !ok {
+			return nil, false
+		}
+		var array expression = &arrayRange{min, max, exp}
+		if min == max {
+			array = &arrayOf{min, exp}
+		}
+		if capture {
+			return &captureOf{array, group, name}, true
+		}
+		return array, true
 
 	case "*":
+		min, max := 1, 1
+		if text, _ := p.peek(); text == "?" {
+			p.next()
+			min = 0
+		} else if text == "+" {
+			p.next()
+			max = -1
+		}
 		exp, ok := p.parseSubExp()
 		if !ok {
 			return nil, false
 		}
-		return &ptrOf{exp}, true
+		if min == max {
+			return &ptrOf{exp}, true
+		}
+		return &ptrRange{min, max, exp}, true
 
 	case "_":
 		return &any{}, true
 
+	case "(":
+		exp, ok := p.parseExp()
+		if !ok {
+			return nil, false
+		}
+		if !p.consume(")") {
+			return nil, false
+		}
+		return exp, true
+
+	case "!":
+		exp, ok := p.parseSubExp()
+		if !ok {
+			return nil, false
+		}
+		// Groups may come from the arguments of a definition, which are parsed
+		// before its body.
+		if hasCapture(exp) {
+			return p.fail("cannot capture under a negation, in !%s", parenthesize(exp, 2))
+		}
+		return &notOf{exp}, true
+
 	case "{":
-		group := p.group
-		p.group++
+		var name string
+		if text, _ := p.peek(); isIdent(text) && text != "_" && p.lookahead(1) == ":" {
+			name = text
+			p.next()
+			p.next()
+		}
+		group, ok := p.group(name)
+		if !ok {
+			return nil, false
+		}
 		exp, ok := p.parseExp()
 		if !ok {
 			return nil, false
@@ -215,7 +535,67 @@ func (p *parser) parseSubExp() (expression, bool) {
 		if ok := p.consume("}"); !ok {
 			return nil, false
 		}
-		return &captureOf{exp, group}, true
+		return &captureOf{exp, group, name}, true
+
+	case "\\":
+		ref, ok := p.next()
+		if !ok {
+			return nil, false
+		}
+		if n, err := strconv.Atoi(ref); err == nil {
+			if n < 1 || len(p.names) < p.base+n {
+				return nil, false
+			}
+			p.backrefs = true
+			return &backrefOf{p.base + n - 1, ""}, true
+		}
+		for i := p.base; i < len(p.names); i++ {
+			if name := p.names[i]; name != "" && name == ref {
+				p.backrefs = true
+				return &backrefOf{i, name}, true
+			}
+		}
+		return nil, false
+
+	case "@":
+		name, ok := p.next()
+		if !ok || !isIdent(name) {
+			return nil, false
+		}
+		var args []expression
+		if text, _ := p.peek(); text == "[" {
+			p.next()
+			if args, ok = p.parseExpList(p.parseExp); !ok {
+				return nil, false
+			}
+			if !p.consume("]") {
+				return nil, false
+			}
+		}
+		return p.expand(name, args)
+
+	case "rec":
+		name, ok := p.next()
+		if !ok || !isIdent(name) || name == "_" || !p.consume(".") {
+			return nil, false
+		}
+		m := &recOf{name, nil}
+		p.recursive = true
+		prev, shadowed := p.params[name]
+		if p.params == nil {
+			p.params = make(map[string]expression)
+		}
+		p.params[name] = &recVar{m}
+		m.exp, ok = p.parseExp()
+		if shadowed {
+			p.params[name] = prev
+		} else {
+			delete(p.params, name)
+		}
+		if !ok {
+			return nil, false
+		}
+		return m, true
 
 	case "map":
 		if ok := p.consume("["); !ok {
@@ -242,7 +622,7 @@ func (p *parser) parseSubExp() (expression, bool) {
 		if !ok {
 			return nil, false
 		}
-		kind, ok := kinds[k]
+		kind, ok := kinds[p.qualified(k)]
 		if !ok {
 			return nil, false
 		}
@@ -252,7 +632,13 @@ func (p *parser) parseSubExp() (expression, bool) {
 		return &kindOf{kind}, true
 
 	case "struct":
-		return &kindOf{reflect.Struct}, true
+		if text, _ := p.peek(); text != "{" {
+			return &kindOf{reflect.Struct}, true
+		} else if _, err := strconv.Atoi(p.lookahead(1)); err == nil {
+			return &kindOf{reflect.Struct}, true
+		}
+		p.next()
+		return p.parseStruct()
 
 	case "alias":
 		if !p.consume("[") {
@@ -265,10 +651,51 @@ func (p *parser) parseSubExp() (expression, bool) {
 		if !p.consume("]") {
 			return nil, false
 		}
-		if e, ok := exp.(*exact); ok {
-			return &aliasOf{&convertibleTo{e.typ}}, true
-		} else {
-			return &aliasOf{exp}, true
+		if typ, ok := typeOfExp(exp); ok {
+			return &aliasOf{&underlyingOf{typ}}, true
+		}
+		return &aliasOf{exp}, true
+
+	case "deref":
+		if !p.consume("[") {
+			return nil, false
+		}
+		exp, ok := p.parseExp()
+		if !ok || !p.consume("]") {
+			return nil, false
+		}
+		return &derefOf{exp}, true
+
+	case "signed", "unsigned", "numeric", "ordered":
+		return &classOf{text}, true
+
+	case "comparable":
+		return &isComparable{}, true
+
+	case "convertible", "exact", "impl", "assignable":
+		if !p.consume("[") {
+			return nil, false
+		}
+		exp, ok := p.parseExp()
+		if !ok || !p.consume("]") {
+			return nil, false
+		}
+		typ, ok := typeOfExp(exp)
+		if !ok {
+			return p.fail("%s[T] expects a type, got %s", text, exp)
+		}
+		switch text {
+		case "convertible":
+			return &convertibleTo{typ}, true
+		case "exact":
+			return &exact{typ}, true
+		case "impl":
+			if typ.Kind() != reflect.Interface {
+				return p.fail("impl[T] expects an interface type, got %s", typ)
+			}
+			return &implOf{typ}, true
+		default:
+			return &assignableTo{typ}, true
 		}
 
 	case "chan":
@@ -281,6 +708,9 @@ func (p *parser) parseSubExp() (expression, bool) {
 				return nil, false
 			}
 			dir = reflect.SendDir
+		} else if text == "?" {
+			p.next()
+			dir = 0
 		}
 		exp, ok := p.parseSubExp()
 		if !ok {
@@ -302,59 +732,437 @@ func (p *parser) parseSubExp() (expression, bool) {
 		return &chanOf{exp, reflect.RecvDir}, true
 
 	case "func":
-		if ok := p.consume("("); !ok {
-			return nil, false
-		}
-		argsExp, ok := p.parseExpList()
+		sig, ok := p.parseSignature()
 		if !ok {
 			return nil, false
 		}
-		if ok := p.consume(")"); !ok {
+		return sig, true
+
+	case "has":
+		m := &hasMethods{}
+		if text, _ := p.peek(); text == "*" {
+			p.next()
+			m.ptr = true
+		}
+		if !p.consume("[") {
 			return nil, false
 		}
-		var returnsExp []expression
-		if text, _ := p.peek(); text == "(" {
-			p.next()
-			returnsExp, ok = p.parseExpList()
+		names := make(map[string]bool)
+		for {
+			name, ok := p.next()
+			if !ok || !isIdent(name) || name == "_" || names[name] {
+				return nil, false
+			}
+			names[name] = true
+			sig, ok := p.parseSignature()
 			if !ok {
 				return nil, false
 			}
-			if ok := p.consume(")"); !ok {
+			m.methods = append(m.methods, method{name, sig})
+			if text, _ := p.next(); text == "]" {
+				return m, true
+			} else if text != "," {
 				return nil, false
 			}
-		} else if !stop[text] {
-			returnExp, ok := p.parseSubExp()
-			if !ok {
+		}
+
+	case "named", "unnamed":
+		if !p.consume("[") {
+			return nil, false
+		}
+		var exp expression
+		if re, _ := p.peek(); text == "named" && len(re) > 1 && strings.HasPrefix(re, "/") {
+			p.next()
+			if !strings.HasSuffix(re, "/") {
 				return nil, false
 			}
-			returnsExp = append(returnsExp, returnExp)
+			compiled, err := regexp.Compile(re[1 : len(re)-1])
+			if err != nil {
+				return nil, false
+			}
+			exp = &nameMatches{compiled}
+		} else if exp, ok = p.parseExp(); !ok {
+			return nil, false
+		}
+		if !p.consume("]") {
+			return nil, false
+		}
+		if text == "unnamed" {
+			return &unnamedOf{exp}, true
 		}
-		return &funcOf{argsExp, returnsExp}, true
+		return &namedOf{exp}, true
+
+	case "pkg":
+		if !p.consume("[") {
+			return nil, false
+		}
+		glob, ok := p.next()
+		if !ok {
+			return nil, false
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, false
+		}
+		if !p.consume("]") {
+			return nil, false
+		}
+		return &pkgOf{glob}, true
+
+	case "interface":
+		if !p.consume("{") {
+			return nil, false
+		}
+		exp, ok := p.parseInterface()
+		if m, isInterface := exp.(*interfaceOf); ok && isInterface && len(m.methods) == 0 && !m.open {
+			// interface{} is any, as in Go.
+			return &implements{types["any"]}, true
+		}
+		return exp, ok
 
 	case "%":
 		argsIndex := p.argsIndex
-		p.argsIndex++
-		if ok := p.consume("T"); !ok {
-			return nil, false
+		if text, _ := p.peek(); text == "[" {
+			p.next()
+			n, ok := p.parseInt()
+			if !ok || !p.consume("]") {
+				return nil, false
+			}
+			if n < 1 {
+				return p.fail("bad argument index %d", n)
+			}
+			argsIndex = n - 1
+		}
+		verb, _ := p.next()
+		if verb != "T" && verb != "K" && verb != "v" {
+			return p.fail("unknown verb %%%s", verb)
 		}
 		if len(p.args) <= argsIndex {
-			return nil, false
+			return p.fail("missing argument %d for %%%s", argsIndex+1, verb)
 		}
+		p.argsIndex = argsIndex + 1
+		if p.used == nil {
+			p.used = make([]bool, len(p.args))
+		}
+		p.used[argsIndex] = true
 		arg := p.args[argsIndex]
-		if typ, ok := arg.(reflect.Type); ok {
-			return exactOrImplements(typ), true
-		} else {
-			return exactOrImplements(reflect.TypeOf(arg)), true
+		switch verb {
+		case "T":
+			if typ := typeOfArg(arg); typ != nil {
+				return exactOrImplements(typ), true
+			}
+			return p.fail("%%T expects a type or a value, got nil")
+		case "K":
+			if kind, ok := arg.(reflect.Kind); ok && kind != reflect.Invalid {
+				return &kindOf{kind}, true
+			}
+			return p.fail("%%K expects a reflect.Kind, got %T", arg)
+		default:
+			r, ok := arg.(*Reflext)
+			if !ok {
+				return p.fail("%%v expects a *Reflext, got %T", arg)
+			} else if r == nil {
+				return p.fail("%%v expects a *Reflext, got a nil *Reflext")
+			}
+			return p.instantiate(r.source, nil)
 		}
 
 	default:
-		if typ, ok := types[text]; ok {
+		if exp, ok := p.params[text]; ok {
+			return exp, true
+		}
+		if !isIdent(text) {
+			return nil, false
+		}
+		name := p.qualified(text)
+		if typ, ok := p.universe.lookup(name); ok {
 			return exactOrImplements(typ), true
 		}
+		return p.fail("unknown identifier %s", name)
+
+	}
+}
+
+// hasCapture tells whether exp has capturing groups. Recursive variables are
+// not followed, since their pattern is checked where it is defined.
+func hasCapture(exp expression) bool {
+	switch e := exp.(type) {
+	case *captureOf:
+		return true
+	case *sliceOf:
+		return hasCapture(e.exp)
+	case *arrayOf:
+		return hasCapture(e.exp)
+	case *arrayRange:
+		return hasCapture(e.exp)
+	case *ptrOf:
+		return hasCapture(e.exp)
+	case *ptrRange:
+		return hasCapture(e.exp)
+	case *derefOf:
+		return hasCapture(e.exp)
+	case *chanOf:
+		return hasCapture(e.exp)
+	case *variadicOf:
+		return hasCapture(e.exp)
+	case *repeatOf:
+		return hasCapture(e.exp)
+	case *aliasOf:
+		return hasCapture(e.exp)
+	case *namedOf:
+		return hasCapture(e.exp)
+	case *unnamedOf:
+		return hasCapture(e.exp)
+	case *notOf:
+		return hasCapture(e.exp)
+	case *recOf:
+		return hasCapture(e.exp)
+	case *mapOf:
+		return hasCapture(e.key) || hasCapture(e.value)
+	case *firstOf:
+		return anyCapture(e.exps)
+	case *allOf:
+		return anyCapture(e.exps)
+	case *funcOf:
+		return anyCapture(e.arguments) || anyCapture(e.returns)
+	case *structOf:
+		for _, f := range e.fields {
+			if hasCapture(f.exp) {
+				return true
+			}
+		}
+	case *interfaceOf:
+		return methodsCapture(e.methods)
+	case *hasMethods:
+		return methodsCapture(e.methods)
+	}
+	return false
+}
+
+func anyCapture(exps []expression) bool {
+	for _, exp := range exps {
+		if hasCapture(exp) {
+			return true
+		}
+	}
+	return false
+}
+
+func methodsCapture(methods []method) bool {
+	for _, m := range methods {
+		if hasCapture(m.sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// group allocates the next group, rejecting names already taken.
+func (p *parser) group(name string) (int, bool) {
+	for _, n := range p.names {
+		if name != "" && n == name {
+			_, ok := p.fail("group %s is defined more than once", name)
+			return 0, ok
+		}
+	}
+	p.names = append(p.names, name)
+	return len(p.names) - 1, true
+}
+
+// parseLength parses the length of an array, either n, m..n, or _ for any
+// length, in which case max is -1.
+func (p *parser) parseLength() (int, int, bool) {
+	if text, _ := p.peek(); text == "_" {
+		p.next()
+		return 0, -1, true
+	}
+	min, ok := p.parseInt()
+	if !ok {
+		return 0, 0, false
+	}
+	if text, _ := p.peek(); text != "." {
+		return min, min, true
+	}
+	if !p.consume(".") || !p.consume(".") {
+		return 0, 0, false
+	}
+	max, ok := p.parseInt()
+	if !ok || max < min {
+		return 0, 0, false
+	}
+	return min, max, true
+}
+
+func (p *parser) parseSignature() (*funcOf, bool) {
+	if ok := p.consume("("); !ok {
 		return nil, false
+	}
+	argsExp, ok := p.parseArgList()
+	if !ok {
+		return nil, false
+	}
+	if ok := p.consume(")"); !ok {
+		return nil, false
+	}
+	var returnsExp []expression
+	if text, _ := p.peek(); text == "(" {
+		p.next()
+		returnsExp, ok = p.parseExpList(p.parseRepeatedExp)
+		if !ok {
+			return nil, false
+		}
+		if ok := p.consume(")"); !ok {
+			return nil, false
+		}
+	} else if !stop[text] && !isLiteral(text) {
+		// A literal after the arguments is the tag of a struct field.
+		returnExp, ok := p.parseSubExp()
+		if !ok {
+			return nil, false
+		}
+		if returnExp, ok = p.parseRepeat(returnExp); !ok {
+			return nil, false
+		}
+		returnsExp = append(returnsExp, returnExp)
+	}
+	return &funcOf{argsExp, returnsExp}, true
+}
 
+func (p *parser) parseInterface() (expression, bool) {
+	m := &interfaceOf{}
+	names := make(map[string]bool)
+	for {
+		text, ok := p.next()
+		if !ok {
+			return nil, false
+		}
+		switch {
+		case text == "}":
+			return m, true
+		case text == ".":
+			if !p.consume(".") || !p.consume(".") {
+				return nil, false
+			}
+			m.open = true
+			if text, _ := p.peek(); text == ";" {
+				p.next()
+			}
+			if !p.consume("}") {
+				return nil, false
+			}
+			return m, true
+		case isIdent(text) && text != "_" && !names[text]:
+			names[text] = true
+			sig, ok := p.parseSignature()
+			if !ok {
+				return nil, false
+			}
+			m.methods = append(m.methods, method{text, sig})
+			if text, _ := p.peek(); text == ";" {
+				p.next()
+			} else if text != "}" {
+				// Errors are located at the unexpected token.
+				p.next()
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
 	}
-	panic("unreachable")
+}
+
+func (p *parser) parseStruct() (expression, bool) {
+	m := &structOf{}
+	for {
+		text, ok := p.next()
+		if !ok {
+			return nil, false
+		}
+		switch {
+		case text == "}":
+			return m, true
+		case text == ".":
+			if !p.consume(".") || !p.consume(".") {
+				return nil, false
+			}
+			m.open = true
+			if text, _ := p.peek(); text == ";" {
+				p.next()
+			}
+			if !p.consume("}") {
+				return nil, false
+			}
+			return m, true
+		case isIdent(text):
+			exp, ok := p.parseExp()
+			if !ok {
+				return nil, false
+			}
+			var tags []tag
+			if text, _ := p.peek(); isLiteral(text) {
+				p.next()
+				if tags, ok = parseTags(text); !ok {
+					return nil, false
+				}
+			}
+			m.fields = append(m.fields, field{text, exp, tags})
+			if text, _ := p.peek(); text == ";" {
+				p.next()
+			} else if text != "}" {
+				// Errors are located at the unexpected token.
+				p.next()
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+}
+
+// parseTags parses a quoted list of tag constraints, each being either a key
+// on its own or a key followed by a quoted value, in the reflect.StructTag
+// convention.
+func parseTags(literal string) ([]tag, bool) {
+	text, err := strconv.Unquote(literal)
+	if err != nil {
+		return nil, false
+	}
+	var tags []tag
+	for text = strings.TrimLeft(text, " "); text != ""; text = strings.TrimLeft(text, " ") {
+		i := strings.IndexAny(text, " :\"")
+		if i < 0 {
+			i = len(text)
+		}
+		if i == 0 {
+			return nil, false
+		}
+		key := text[:i]
+		text = text[i:]
+		if !strings.HasPrefix(text, ":") {
+			tags = append(tags, tag{key, "*"})
+			continue
+		}
+		text = text[1:]
+		if !strings.HasPrefix(text, `"`) {
+			return nil, false
+		}
+		j := 1
+		for j < len(text) && text[j] != '"' {
+			if text[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(text) {
+			return nil, false
+		}
+		value, err := strconv.Unquote(text[:j+1])
+		if err != nil {
+			return nil, false
+		}
+		tags = append(tags, tag{key, value})
+		text = text[j+1:]
+	}
+	return tags, true
 }
 
 func (p *parser) next() (string, bool) {
@@ -374,6 +1182,47 @@ func (p *parser) peek() (string, bool) {
 	return tok.text, true
 }
 
+func (p *parser) lookahead(n int) string {
+	if len(p.tokens) <= p.index+n {
+		return ""
+	}
+	return p.tokens[p.index+n].text
+}
+
+// qualified reads the rest of a qualified identifier, such as http.Request or
+// net/http.Handler, whose parts are written without spaces.
+func (p *parser) qualified(text string) string {
+	for {
+		sep, part := p.lookahead(0), p.lookahead(1)
+		if sep != "." && sep != "/" && sep != "-" || !isIdent(part) || !p.adjacent(p.index-1) || !p.adjacent(p.index) {
+			return text
+		}
+		p.next()
+		p.next()
+		text += sep + part
+	}
+}
+
+// adjacent tells whether the i-th token is immediately followed by the next.
+func (p *parser) adjacent(i int) bool {
+	if i < 0 || len(p.tokens) <= i+1 {
+		return false
+	}
+	return p.tokens[i].pos.Offset+len(p.tokens[i].text) == p.tokens[i+1].pos.Offset
+}
+
+func (p *parser) parseInt() (int, bool) {
+	text, ok := p.next()
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(text, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int(n), true
+}
+
 func (p *parser) consume(match string) bool {
 	if text, ok := p.next(); !ok {
 		return false
@@ -385,6 +1234,10 @@ func (p *parser) consume(match string) bool {
 func tokenize(expr string) []token {
 	var s scanner.Scanner
 	s.Init(strings.NewReader(expr))
+	return scan(&s)
+}
+
+func scan(s *scanner.Scanner) []token {
 	var tok rune
 	var tokens []token
 	for tok != scanner.EOF {
@@ -392,16 +1245,140 @@ func tokenize(expr string) []token {
 		text := s.TokenText()
 		if text == "" {
 			return tokens
+		} else if tok == scanner.Float {
+			// Floats swallow the dots of array lengths, as in [1..16]E.
+			pos := s.Position
+			for _, part := range splitDots(text) {
+				tokens = append(tokens, token{
+					text: part,
+					pos:  pos,
+				})
+				pos.Offset += len(part)
+				pos.Column += len(part)
+			}
 		} else {
 			tokens = append(tokens, token{
-				text:   text,
-				offset: s.Pos().Offset,
+				text: text,
+				pos:  s.Position,
 			})
 		}
+		if n := len(tokens); tok == '[' && n > 1 {
+			pos := s.Pos()
+			if raw := scanRaw(s, tokens[n-2].text); raw != "" {
+				tokens = append(tokens, token{
+					text: raw,
+					pos:  pos,
+				})
+			}
+		}
 	}
 	panic("unreachable")
 }
 
+// splitDots splits text before and after each dot.
+func splitDots(text string) []string {
+	var parts []string
+	for {
+		i := strings.IndexByte(text, '.')
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			parts = append(parts, text[:i])
+		}
+		parts = append(parts, ".")
+		text = text[i+1:]
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+// scanRaw reads the regular expression of named[/re/], and the glob of
+// pkg[glob], which the scanner would otherwise split or treat as comments.
+func scanRaw(s *scanner.Scanner, selector string) string {
+	for s.Peek() == ' ' || s.Peek() == '\t' || s.Peek() == '\n' {
+		s.Next()
+	}
+	var raw []rune
+	switch {
+	case selector == "named" && s.Peek() == '/':
+		raw = append(raw, s.Next())
+		for ch := s.Next(); ch != scanner.EOF; ch = s.Next() {
+			raw = append(raw, ch)
+			if ch == '\\' && s.Peek() == '/' {
+				raw = append(raw, s.Next())
+			} else if ch == '/' {
+				break
+			}
+		}
+	case selector == "pkg":
+		for ch := s.Peek(); ch != scanner.EOF && ch != ']'; ch = s.Peek() {
+			raw = append(raw, s.Next())
+		}
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+func isLiteral(text string) bool {
+	return strings.HasPrefix(text, "`") || strings.HasPrefix(text, `"`)
+}
+
+func isIdent(text string) bool {
+	for i, r := range text {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return text != ""
+}
+
+// typeOfArg returns the type given as a reflect.Type, or else the type of the
+// value given.
+func typeOfArg(arg interface{}) reflect.Type {
+	if typ, ok := arg.(reflect.Type); ok {
+		return typ
+	}
+	return reflect.TypeOf(arg)
+}
+
+// typeOfExp returns the type denoted by expressions built of types only, such
+// as error or map[string][]int.
+func typeOfExp(exp expression) (reflect.Type, bool) {
+	switch e := exp.(type) {
+	case *exact:
+		return e.typ, true
+	case *implements:
+		return e.typ, true
+	case *sliceOf:
+		if elem, ok := typeOfExp(e.exp); ok {
+			return reflect.SliceOf(elem), true
+		}
+	case *arrayOf:
+		if elem, ok := typeOfExp(e.exp); ok {
+			return reflect.ArrayOf(e.size, elem), true
+		}
+	case *ptrOf:
+		if elem, ok := typeOfExp(e.exp); ok {
+			return reflect.PtrTo(elem), true
+		}
+	case *chanOf:
+		if elem, ok := typeOfExp(e.exp); ok && e.dir != 0 {
+			return reflect.ChanOf(e.dir, elem), true
+		}
+	case *mapOf:
+		key, ok := typeOfExp(e.key)
+		if !ok || !key.Comparable() {
+			return nil, false
+		}
+		if elem, ok := typeOfExp(e.value); ok {
+			return reflect.MapOf(key, elem), true
+		}
+	}
+	return nil, false
+}
+
 func exactOrImplements(typ reflect.Type) expression {
 	if typ.Kind() == reflect.Interface {
 		return &implements{typ}
@@ -14,6 +14,8 @@ package reflext
 
 import (
 	"reflect"
+	"regexp"
+	"strings"
 
 	. "gopkg.in/check.v1"
 )
@@ -25,10 +27,22 @@ func (_ *ReflextSuite) TestParser_good(c *C) {
 		"[]bool":         &sliceOf{&exact{types["bool"]}},
 		"[][]rune":       &sliceOf{&sliceOf{&exact{types["rune"]}}},
 		"*string":        &ptrOf{&exact{types["string"]}},
+		"*?{struct}":     &ptrRange{0, 1, &captureOf{&kindOf{reflect.Struct}, 0, ""}},
+		"*+_":            &ptrRange{1, -1, &any{}},
+		"*?*+int":        &ptrRange{0, 1, &ptrRange{1, -1, &exact{types["int"]}}},
+		"deref[int | _]": &derefOf{&firstOf{[]expression{&exact{types["int"]}, &any{}}}},
 		"map[byte]error": &mapOf{&exact{types["byte"]}, &implements{types["error"]}},
 		"chan int":       &chanOf{&exact{types["int"]}, reflect.BothDir},
 		"chan <- int":    &chanOf{&exact{types["int"]}, reflect.SendDir},
 		"<- chan int":    &chanOf{&exact{types["int"]}, reflect.RecvDir},
+		"chan? int":      &chanOf{&exact{types["int"]}, 0},
+		"[_]int":         &arrayRange{0, -1, &exact{types["int"]}},
+		"[1..16]byte":    &arrayRange{1, 16, &exact{types["byte"]}},
+		"[2..2]_":        &arrayOf{2, &any{}},
+		"[{n}]byte":      &captureOf{&arrayRange{0, -1, &exact{types["byte"]}}, 0, "n"},
+		"[{2}]_":         &captureOf{&arrayOf{2, &any{}}, 0, ""},
+		"[{_}]int":       &captureOf{&arrayRange{0, -1, &exact{types["int"]}}, 0, ""},
+		"[{l: 1..2}]{_}": &captureOf{&arrayRange{1, 2, &captureOf{&any{}, 1, ""}}, 0, "l"},
 		"kind[uint8]":    &kindOf{kinds["uint8"]},
 		"struct":         &kindOf{kinds["struct"]},
 		"_":              &any{},
@@ -37,17 +51,50 @@ func (_ *ReflextSuite) TestParser_good(c *C) {
 			&exact{types["int"]}, &kindOf{kinds["int"]},
 			&exact{types["uint"]}, &kindOf{kinds["uint"]},
 		}},
-		"map[_]_ | _":   &firstOf{[]expression{&mapOf{&any{}, &any{}}, &any{}}},
-		"*_ | _":        &firstOf{[]expression{&ptrOf{&any{}}, &any{}}},
-		"chan _ | _":    &firstOf{[]expression{&chanOf{&any{}, reflect.BothDir}, &any{}}},
-		"func() _ | _":  &firstOf{[]expression{&funcOf{nil, []expression{&any{}}}, &any{}}},
-		"{int}":         &captureOf{&exact{types["int"]}, 0},
-		"map[{_}]*{_}":  &mapOf{&captureOf{&any{}, 0}, &ptrOf{&captureOf{&any{}, 1}}},
-		"{{int | {_}}}": &captureOf{&captureOf{&firstOf{[]expression{&exact{types["int"]}, &captureOf{&any{}, 2}}}, 1}, 0},
+		"map[_]_ | _":    &firstOf{[]expression{&mapOf{&any{}, &any{}}, &any{}}},
+		"*_ | _":         &firstOf{[]expression{&ptrOf{&any{}}, &any{}}},
+		"chan _ | _":     &firstOf{[]expression{&chanOf{&any{}, reflect.BothDir}, &any{}}},
+		"func() _ | _":   &firstOf{[]expression{&funcOf{nil, []expression{&any{}}}, &any{}}},
+		"{int}":          &captureOf{&exact{types["int"]}, 0, ""},
+		"map[{_}]*{_}":   &mapOf{&captureOf{&any{}, 0, ""}, &ptrOf{&captureOf{&any{}, 1, ""}}},
+		"{{int | {_}}}":  &captureOf{&captureOf{&firstOf{[]expression{&exact{types["int"]}, &captureOf{&any{}, 2, ""}}}, 1, ""}, 0, ""},
+		"{req: *struct}": &captureOf{&ptrOf{&kindOf{reflect.Struct}}, 0, "req"},
+		"map[{k: _}]{_}": &mapOf{&captureOf{&any{}, 0, "k"}, &captureOf{&any{}, 1, ""}},
+		"func({_}, {a: _}) (\\1, \\a)": &funcOf{
+			[]expression{&captureOf{&any{}, 0, ""}, &captureOf{&any{}, 1, "a"}},
+			[]expression{&backrefOf{0, ""}, &backrefOf{1, "a"}},
+		},
+		"!int | uint & _": &firstOf{[]expression{
+			&notOf{&exact{types["int"]}},
+			&allOf{[]expression{&exact{types["uint"]}, &any{}}},
+		}},
+		"*!_ & map[_]_":        &allOf{[]expression{&ptrOf{&notOf{&any{}}}, &mapOf{&any{}, &any{}}}},
+		"func() & _":           &allOf{[]expression{&funcOf{nil, nil}, &any{}}},
+		"(_)":                  &any{},
+		"[]int | []bool":       &firstOf{[]expression{&sliceOf{&exact{types["int"]}}, &sliceOf{&exact{types["bool"]}}}},
+		"map[string](int | _)": &mapOf{&exact{types["string"]}, &firstOf{[]expression{&exact{types["int"]}, &any{}}}},
+		"func((int | _)?) (_)": &funcOf{[]expression{&repeatOf{&firstOf{[]expression{&exact{types["int"]}, &any{}}}, 0, 1}}, []expression{&any{}}},
 
 		// alias
-		"alias[string]":     &aliasOf{&convertibleTo{types["string"]}},
-		"alias[chan uint8]": &aliasOf{&chanOf{&exact{types["uint8"]}, reflect.BothDir}},
+		"alias[string]":           &aliasOf{&underlyingOf{types["string"]}},
+		"alias[error]":            &aliasOf{&underlyingOf{types["error"]}},
+		"alias[chan uint8]":       &aliasOf{&underlyingOf{reflect.TypeOf(make(chan uint8))}},
+		"convertible[int]":        &convertibleTo{types["int"]},
+		"alias[convertible[int]]": &aliasOf{&convertibleTo{types["int"]}},
+
+		// selectors
+		"signed":                     &classOf{"signed"},
+		"comparable":                 &isComparable{},
+		"any":                        &implements{types["any"]},
+		"exact[interface{}]":         &exact{types["any"]},
+		"unsafe.Pointer":             &exact{types["unsafe.Pointer"]},
+		"kind[unsafe.Pointer]":       &kindOf{reflect.UnsafePointer},
+		"exact[error]":               &exact{types["error"]},
+		"impl[error]":                &implOf{types["error"]},
+		"assignable[error]":          &assignableTo{types["error"]},
+		"assignable[int]":            &assignableTo{types["int"]},
+		"exact[map[string][]*int]":   &exact{reflect.TypeOf(map[string][]*int{})},
+		"convertible[<-chan [2]int]": &convertibleTo{reflect.TypeOf(make(<-chan [2]int))},
 
 		// func
 		"func(int)": &funcOf{
@@ -70,6 +117,48 @@ func (_ *ReflextSuite) TestParser_good(c *C) {
 			[]expression{&firstOf{[]expression{&exact{types["int"]}, &exact{types["uint"]}}}, &exact{types["bool"]}},
 			[]expression{&exact{types["int"]}, &exact{types["int"]}},
 		},
+		"func(string, ...{_})": &funcOf{
+			[]expression{&exact{types["string"]}, &variadicOf{&captureOf{&any{}, 0, ""}}},
+			nil,
+		},
+		"func(_*) error?": &funcOf{
+			[]expression{&repeatOf{&any{}, 0, -1}},
+			[]expression{&repeatOf{&implements{types["error"]}, 0, 1}},
+		},
+		"func(int+, _{1,3}) (_{2}, _{2,})": &funcOf{
+			[]expression{&repeatOf{&exact{types["int"]}, 1, -1}, &repeatOf{&any{}, 1, 3}},
+			[]expression{&repeatOf{&any{}, 2, 2}, &repeatOf{&any{}, 2, -1}},
+		},
+
+		// struct
+		"struct{}":      &structOf{nil, false},
+		"struct{ ... }": &structOf{nil, true},
+		"struct{ Name string; ID {_}; ... }": &structOf{[]field{
+			{"Name", &exact{types["string"]}, nil},
+			{"ID", &captureOf{&any{}, 0, ""}, nil},
+		}, true},
+		"struct{ _ func() }": &structOf{[]field{{"_", &funcOf{nil, nil}, nil}}, false},
+		"struct{ A func() `json:\"a\"` }": &structOf{[]field{
+			{"A", &funcOf{nil, nil}, []tag{{"json", "a"}}},
+		}, false},
+		"struct{ _ string `json:\"id\" db`; ... }": &structOf{[]field{
+			{"_", &exact{types["string"]}, []tag{{"json", "id"}, {"db", "*"}}},
+		}, true},
+
+		// interface
+		"interface{}": &implements{types["any"]},
+		"interface{ Close() error; ... }": &interfaceOf{[]method{
+			{"Close", &funcOf{nil, []expression{&implements{types["error"]}}}},
+		}, true},
+		"has*[Close() error]": &hasMethods{[]method{
+			{"Close", &funcOf{nil, []expression{&implements{types["error"]}}}},
+		}, true},
+
+		// named and pkg
+		"named[/^My/]":    &namedOf{&nameMatches{regexp.MustCompile("^My")}},
+		"named[_]":        &namedOf{&any{}},
+		"unnamed[struct]": &unnamedOf{&kindOf{reflect.Struct}},
+		"pkg[net/*]":      &pkgOf{"net/*"},
 
 		// Concrete
 		"%T":           &exact{types["int"]},
@@ -80,12 +169,13 @@ func (_ *ReflextSuite) TestParser_good(c *C) {
 		c.Log(s)
 
 		// Parse
-		actual, _, err := parse(s, 0, true, "")
+		args := []interface{}{0, true, ""}[:strings.Count(s, "%")]
+		actual, _, err := parse(s, args...)
 		c.Assert(err, IsNil)
 		c.Assert(actual, DeepEquals, expected)
 
 		// Check round trip (expression > string > expression) is identity function
-		actual2, _, err := parse(expected.String(), 0, true, "")
+		actual2, _, err := parse(expected.String())
 		c.Assert(err, IsNil)
 		c.Assert(actual2, DeepEquals, expected)
 	}
@@ -98,13 +188,145 @@ func (_ *ReflextSuite) TestParser_concreteWithInterface(c *C) {
 	c.Assert(actual.String(), Equals, "reflext.someInterface")
 }
 
+func (_ *ReflextSuite) TestParser_verbs(c *C) {
+	examples := []struct {
+		s        string
+		args     []interface{}
+		expected expression
+	}{
+		{"%[2]T | %[1]T | %T", []interface{}{0, true}, &firstOf{[]expression{
+			&exact{types["bool"]}, &exact{types["int"]}, &exact{types["bool"]},
+		}}},
+		{"map[%T]%[1]T", []interface{}{""}, &mapOf{&exact{types["string"]}, &exact{types["string"]}}},
+		{"*%K", []interface{}{reflect.Struct}, &ptrOf{&kindOf{reflect.Struct}}},
+	}
+	for _, eg := range examples {
+		c.Log(eg.s)
+		actual, _, err := parse(eg.s, eg.args...)
+		c.Assert(err, IsNil)
+		c.Assert(actual, DeepEquals, eg.expected)
+	}
+}
+
+func (_ *ReflextSuite) TestParser_badVerbs(c *C) {
+	examples := []struct {
+		s        string
+		args     []interface{}
+		expected string
+	}{
+		{"int", []interface{}{0}, "argument 1 is not used"},
+		{"%T | %[1]T", []interface{}{0, true}, "argument 2 is not used"},
+		{"%T", nil, "missing argument 1 for %T"},
+		{"%[3]T", []interface{}{0}, "missing argument 3 for %T"},
+		{"%[0]T", []interface{}{0}, "bad argument index 0"},
+		{"%x", []interface{}{0}, "unknown verb %x"},
+		{"%T", []interface{}{nil}, "%T expects a type or a value, got nil"},
+		{"%K", []interface{}{0}, "%K expects a reflect.Kind, got int"},
+		{"%v", []interface{}{"int"}, "%v expects a \\*Reflext, got string"},
+		{"%v", []interface{}{(*Reflext)(nil)}, "%v expects a \\*Reflext, got a nil \\*Reflext"},
+	}
+	for _, eg := range examples {
+		c.Log(eg.s)
+		_, _, err := parse(eg.s, eg.args...)
+		c.Assert(err, ErrorMatches, eg.expected)
+	}
+}
+
+func (_ *ReflextSuite) TestParser_rec(c *C) {
+	actual, _, err := parse("rec L. *struct{ Next L; ... } | int")
+	c.Assert(err, IsNil)
+	rec := &recOf{"L", nil}
+	rec.exp = &firstOf{[]expression{
+		&ptrOf{&structOf{[]field{{"Next", &recVar{rec}, nil}}, true}},
+		&exact{types["int"]},
+	}}
+	c.Assert(actual, DeepEquals, rec)
+	c.Assert(actual.String(), Equals, "rec L. *struct{ Next L; ... } | int")
+}
+
 func (_ *ReflextSuite) TestParser_bad(c *C) {
 	examples := []string{
 		"int int",
 		"[ int",
 		"] int",
 		"[w]int",
+		"[2..1]int",
+		"[1..]int",
+		"[1.2]int",
+		"[-1]int",
+		"[_",
+		"[{n}",
+		"[{}]int",
+		"[{n:}]int",
+		"{n: _} | [{n}]int",
+		"chan ?",
+		"*?",
+		"*+",
+		"*?+int",
+		"deref",
+		"deref[int",
+		"deref[]",
 		"%t",
+		"func(...int, bool)",
+		"func() ...int",
+		"func(..int)",
+		"func(_{3,1})",
+		"func(_{1,)",
+		"func(..._*)",
+		"struct{",
+		"struct{ A }",
+		"struct{ A int B int }",
+		"struct{ ...; A int }",
+		"struct{ 1 int }",
+		"struct{ A int `json:id` }",
+		"struct{ A int `:\"id\"` }",
+		"struct{ A int `json:\"id` }",
+		"interface",
+		"interface{ M() int; M() }",
+		"interface{ _() }",
+		"interface{ M }",
+		"has[]",
+		"has[M(), M()]",
+		"has[M() N()]",
+		"named[/(/]",
+		"named[/x]",
+		"unnamed[/x/]",
+		"pkg[]",
+		"pkg[[]",
+		"{_: int}",
+		"{a: int} | {a: uint}",
+		"{a int}",
+		"\\1",
+		"{_} | \\0",
+		"{_} | \\2",
+		"map[\\1]{_}",
+		"{_} | \\a",
+		"!{int}",
+		"!*{_}",
+		"convertible[_]",
+		"convertible[int",
+		"exact[_]",
+		"exact[interface{ ... }]",
+		"kind[unsafe]",
+		"kind[signed]",
+		"exact[*_]",
+		"exact[map[[]int]int]",
+		"impl[int]",
+		"assignable[int | uint]",
+		"int &",
+		"& int",
+		"int & | uint",
+		"()",
+		"(int",
+		"int)",
+		"rec",
+		"rec L",
+		"rec L *L",
+		"rec _. _",
+		"rec L.",
+		"L",
+		"(rec L. *L) | L",
+		"!{rec L. *L}",
 	}
 	for _, s := range examples {
 		_, _, err := parse(s)
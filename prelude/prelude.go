@@ -0,0 +1,77 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prelude binds commonly used types of the standard library, such
+// that patterns can refer to them by name, as in
+//
+//	prelude.Universe.MustCompile("func(context.Context, *{struct}) error")
+package prelude
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"time"
+
+	"github.com/pascallouisperez/reflext"
+)
+
+// Universe binds each type both by package name and by package path, e.g.
+// http.Handler and net/http.Handler.
+var Universe = with(nil, []binding{
+	{"context", "Context", typeOf((*context.Context)(nil))},
+	{"database/sql", "Scanner", typeOf((*sql.Scanner)(nil))},
+	{"encoding/json", "Marshaler", typeOf((*json.Marshaler)(nil))},
+	{"encoding/json", "Unmarshaler", typeOf((*json.Unmarshaler)(nil))},
+	{"fmt", "Stringer", typeOf((*fmt.Stringer)(nil))},
+	{"io", "Reader", typeOf((*io.Reader)(nil))},
+	{"io", "Writer", typeOf((*io.Writer)(nil))},
+	{"io", "Closer", typeOf((*io.Closer)(nil))},
+	{"io", "ReadCloser", typeOf((*io.ReadCloser)(nil))},
+	{"io", "ReadWriter", typeOf((*io.ReadWriter)(nil))},
+	{"io", "WriteCloser", typeOf((*io.WriteCloser)(nil))},
+	{"net/http", "Handler", typeOf((*http.Handler)(nil))},
+	{"net/http", "HandlerFunc", reflect.TypeOf(http.HandlerFunc(nil))},
+	{"net/http", "Header", reflect.TypeOf(http.Header{})},
+	{"net/http", "Request", reflect.TypeOf(http.Request{})},
+	{"net/http", "Response", reflect.TypeOf(http.Response{})},
+	{"net/http", "ResponseWriter", typeOf((*http.ResponseWriter)(nil))},
+	{"time", "Duration", reflect.TypeOf(time.Duration(0))},
+	{"time", "Time", reflect.TypeOf(time.Time{})},
+})
+
+// binding names types explicitly, since the package path of a type may differ
+// from the one it is imported from, as with type aliases.
+type binding struct {
+	pkg  string
+	name string
+	typ  reflect.Type
+}
+
+func typeOf(ptr interface{}) reflect.Type {
+	return reflect.TypeOf(ptr).Elem()
+}
+
+func with(u *reflext.Universe, bindings []binding) *reflext.Universe {
+	for _, b := range bindings {
+		u = u.With(path.Base(b.pkg)+"."+b.name, b.typ)
+		if b.pkg != path.Base(b.pkg) {
+			u = u.With(b.pkg+"."+b.name, b.typ)
+		}
+	}
+	return u
+}
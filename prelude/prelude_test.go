@@ -0,0 +1,68 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prelude
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type PreludeSuite struct{}
+
+var _ = Suite(&PreludeSuite{})
+
+func (_ *PreludeSuite) TestUniverse(c *C) {
+	examples := map[string]struct {
+		matches, doesnt []interface{}
+	}{
+		"func(context.Context, *{struct}) error": {
+			matches: []interface{}{func(context.Context, *struct{}) error { return nil }},
+			doesnt:  []interface{}{func(int, *struct{}) error { return nil }},
+		},
+		"func(http.ResponseWriter, *http.Request)": {
+			matches: []interface{}{func(http.ResponseWriter, *http.Request) {}},
+		},
+		"net/http.Handler": {
+			matches: []interface{}{http.NotFoundHandler(), http.HandlerFunc(nil)},
+			doesnt:  []interface{}{0},
+		},
+		"io.Reader & io.Writer & fmt.Stringer": {
+			matches: []interface{}{&bytes.Buffer{}},
+			doesnt:  []interface{}{bytes.NewReader(nil)},
+		},
+		"time.Duration | time.Time": {
+			matches: []interface{}{time.Second, time.Time{}},
+		},
+		"json.Marshaler | encoding/json.Unmarshaler | sql.Scanner | database/sql.Scanner": {
+			matches: []interface{}{time.Time{}},
+		},
+	}
+	for s, cases := range examples {
+		r := Universe.MustCompile(s)
+		for _, value := range cases.matches {
+			c.Logf("%s matches %T", s, value)
+			c.Assert(r.Match(value), Equals, true)
+		}
+		for _, value := range cases.doesnt {
+			c.Logf("%s does not match %T", s, value)
+			c.Assert(r.Match(value), Equals, false)
+		}
+	}
+}
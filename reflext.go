@@ -14,19 +14,33 @@ package reflext
 
 import (
 	"reflect"
+	"strconv"
 )
 
 type Reflext struct {
 	expression
-	numGroup int
+	names []string
+
+	// backrefs tells whether the pattern has backreferences, without which
+	// captures cannot change whether it matches, so that Match does not record
+	// them.
+	backrefs bool
+
+	// recursive tells whether the pattern has recursive patterns, which keep
+	// their assumptions along with the groups.
+	recursive bool
+
+	// source is used to splice the pattern into others with %v.
+	source *definition
 }
 
 func Compile(s string, args ...interface{}) (*Reflext, error) {
-	exp, numGroup, err := parse(s, args...)
-	if err != nil {
-		return nil, err
+	p := &parser{
+		tokens: tokenize(s),
+		index:  0,
+		args:   args,
 	}
-	return &Reflext{exp, numGroup}, nil
+	return p.compile(s)
 }
 
 func MustCompile(s string, args ...interface{}) *Reflext {
@@ -37,12 +51,38 @@ func MustCompile(s string, args ...interface{}) *Reflext {
 	return r
 }
 
+func (r *Reflext) NumSubexp() int {
+	return len(r.names)
+}
+
+// SubexpNames returns the names of the capturing groups, in order. Unnamed
+// groups have an empty name. The slice should not be modified.
+func (r *Reflext) SubexpNames() []string {
+	return r.names
+}
+
+// SubexpIndex returns the index of the group with the given name, or -1 if
+// there is no such group.
+func (r *Reflext) SubexpIndex(name string) int {
+	if name != "" {
+		for i, n := range r.names {
+			if n == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
 func (r *Reflext) Match(value interface{}) bool {
 	return r.MatchInType(reflect.TypeOf(value))
 }
 
 func (r *Reflext) MatchInType(t reflect.Type) bool {
-	return r.expression.Match(t, nil)
+	if !r.backrefs && !r.recursive {
+		return r.expression.Match(t, nil, accept)
+	}
+	return r.expression.Match(t, newGroups(len(r.names)), accept)
 }
 
 func (r *Reflext) FindAll(value interface{}) ([]reflect.Type, bool) {
@@ -50,9 +90,150 @@ func (r *Reflext) FindAll(value interface{}) ([]reflect.Type, bool) {
 }
 
 func (r *Reflext) FindAllInType(t reflect.Type) ([]reflect.Type, bool) {
-	captured := make([]reflect.Type, r.numGroup, r.numGroup)
-	if ok := r.expression.Match(t, &captured); !ok {
+	m, ok := r.FindMatchInType(t)
+	if !ok {
 		return nil, false
 	}
-	return captured, true
+	return m.types, true
+}
+
+func (r *Reflext) FindMatch(value interface{}) (*MatchResult, bool) {
+	return r.FindMatchInType(reflect.TypeOf(value))
+}
+
+func (r *Reflext) FindMatchInType(t reflect.Type) (*MatchResult, bool) {
+	if len(r.names) == 0 {
+		if !r.expression.Match(t, nil, accept) {
+			return nil, false
+		}
+		return &MatchResult{r, []reflect.Type{}, []bool{}, [][]reflect.Type{}}, true
+	}
+	var m *MatchResult
+	captures := newGroups(len(r.names))
+	r.expression.Match(t, captures, func() bool {
+		m = r.result(captures)
+		return true
+	})
+	return m, m != nil
+}
+
+func (r *Reflext) FindAllMatches(value interface{}, yield func(*MatchResult) bool) {
+	r.FindAllMatchesInType(reflect.TypeOf(value), yield)
+}
+
+// FindAllMatchesInType calls yield once for every distinct way the pattern
+// matches t, until yield returns false. Ways differ by the types captured by
+// each group, such as with alternatives, repetitions or struct fields assigned
+// to wildcard names, while ways which capture the same types are only
+// reported once. To do so, the types captured by each way reported so far are
+// kept until the call returns.
+func (r *Reflext) FindAllMatchesInType(t reflect.Type, yield func(*MatchResult) bool) {
+	if len(r.names) == 0 {
+		if m, ok := r.FindMatchInType(t); ok {
+			yield(m)
+		}
+		return
+	}
+	var (
+		seen = make(map[string]bool)
+		ids  = make(map[reflect.Type]int64)
+		key  []byte
+	)
+	captures := newGroups(len(r.names))
+	r.expression.Match(t, captures, func() bool {
+		key = capturesKey(key[:0], captures, ids)
+		if seen[string(key)] {
+			return false
+		}
+		seen[string(key)] = true
+		return !yield(r.result(captures))
+	})
+}
+
+// capturesKey appends to key the types captured by every group, in order,
+// identifying types by the number ids gives them.
+func capturesKey(key []byte, captures *groups, ids map[reflect.Type]int64) []byte {
+	for _, h := range captures.history {
+		for _, typ := range h {
+			id, ok := ids[typ]
+			if !ok {
+				id = int64(len(ids))
+				ids[typ] = id
+			}
+			key = strconv.AppendInt(key, id, 10)
+			key = append(key, ',')
+		}
+		key = append(key, ';')
+	}
+	return key
+}
+
+func (r *Reflext) result(captures *groups) *MatchResult {
+	m := &MatchResult{
+		r:       r,
+		types:   append(make([]reflect.Type, 0, len(captures.types)), captures.types...),
+		matched: append(make([]bool, 0, len(captures.matched)), captures.matched...),
+		history: make([][]reflect.Type, len(captures.history)),
+	}
+	for i, h := range captures.history {
+		m.history[i] = append([]reflect.Type(nil), h...)
+	}
+	return m
+}
+
+// MatchResult holds the types captured by each group of a successful match.
+type MatchResult struct {
+	r       *Reflext
+	types   []reflect.Type
+	matched []bool
+	history [][]reflect.Type
+}
+
+func (m *MatchResult) NumGroup() int {
+	return len(m.types)
+}
+
+// Group returns the type captured by the i-th group, and whether that group
+// took part in the match.
+func (m *MatchResult) Group(i int) (reflect.Type, bool) {
+	return m.types[i], m.matched[i]
+}
+
+// Named returns the type captured by the group with the given name, and
+// whether that group took part in the match.
+func (m *MatchResult) Named(name string) (reflect.Type, bool) {
+	i := m.r.SubexpIndex(name)
+	if i < 0 {
+		return nil, false
+	}
+	return m.Group(i)
+}
+
+// Len returns the length of the array captured by the i-th group, as with
+// [{n}]byte, and whether that group took part in the match.
+func (m *MatchResult) Len(i int) (int, bool) {
+	typ, ok := m.Group(i)
+	if !ok || typ.Kind() != reflect.Array {
+		return 0, false
+	}
+	return typ.Len(), true
+}
+
+// Captures returns every type captured by the i-th group, in order. It has
+// more than one element when the group is under a repetition, such as
+// func({_}*), in which case Group returns the last one.
+func (m *MatchResult) Captures(i int) []reflect.Type {
+	return m.history[i]
+}
+
+func (m *MatchResult) NamedCaptures(name string) []reflect.Type {
+	i := m.r.SubexpIndex(name)
+	if i < 0 {
+		return nil
+	}
+	return m.Captures(i)
+}
+
+func (m *MatchResult) SubexpNames() []string {
+	return m.r.SubexpNames()
 }
@@ -16,6 +16,8 @@ import (
 	"errors"
 	. "gopkg.in/check.v1"
 	"reflect"
+	"strings"
+	"unsafe"
 )
 
 func (_ *ReflextSuite) TestMatchInType(c *C) {
@@ -58,10 +60,221 @@ func (_ *ReflextSuite) TestMatchInType(c *C) {
 			matches: []interface{}{make(<-chan int)},
 			doesnt:  []interface{}{make(chan bool), make(chan<- int), make(chan int), new(int)},
 		},
+		"chan? int": {
+			matches: []interface{}{make(chan int), make(chan<- int), make(<-chan int)},
+			doesnt:  []interface{}{make(chan bool), new(int)},
+		},
+		"*?int": {
+			matches: []interface{}{0, new(int)},
+			doesnt:  []interface{}{new(*int), uint(0)},
+		},
+		"*+int": {
+			matches: []interface{}{new(int), new(*int), new(**int)},
+			doesnt:  []interface{}{0, new(uint)},
+		},
+		"*+*int": {
+			matches: []interface{}{new(*int), new(**int)},
+			doesnt:  []interface{}{new(int)},
+		},
+		"*+int | deref[int]": {
+			matches: []interface{}{new(*int)},
+			doesnt:  []interface{}{cyclicPtr(nil), cyclicA(nil)},
+		},
+		"*+named[/^cyclicPtr$/]": {
+			matches: []interface{}{cyclicPtr(nil)},
+		},
+		"deref[kind[ptr]] & *?*?_": {
+			matches: []interface{}{cyclicPtr(nil), cyclicA(nil), cyclicB(nil)},
+			doesnt:  []interface{}{new(int)},
+		},
+		"deref[struct]": {
+			matches: []interface{}{struct{}{}, &struct{}{}, new(*struct{})},
+			doesnt:  []interface{}{0, new(*int)},
+		},
+		"deref[*_]": {
+			doesnt: []interface{}{0, new(int), new(*int)},
+		},
+		"[_]byte": {
+			matches: []interface{}{[0]byte{}, [16]byte{}, [32]uint8{}},
+			doesnt:  []interface{}{[]byte{}, [16]int8{}, ""},
+		},
+		"[1..16]byte": {
+			matches: []interface{}{[1]byte{}, [16]byte{}},
+			doesnt:  []interface{}{[0]byte{}, [17]byte{}, []byte{}},
+		},
 		"kind[struct]": {
 			matches: []interface{}{struct{}{}, struct{ int }{0}},
 			doesnt:  []interface{}{map[int]bool{}},
 		},
+		"struct{}": {
+			matches: []interface{}{struct{}{}},
+			doesnt:  []interface{}{struct{ int }{0}, 0},
+		},
+		"struct{ Name string; ID _ }": {
+			matches: []interface{}{
+				struct {
+					Name string
+					ID   int
+				}{},
+				struct {
+					ID   bool
+					Name string
+				}{},
+			},
+			doesnt: []interface{}{
+				struct{ Name string }{},
+				struct {
+					Name string
+					ID   int
+					Age  int
+				}{},
+				struct {
+					Name int
+					ID   int
+				}{},
+			},
+		},
+		"struct{ Name string; ... }": {
+			matches: []interface{}{
+				struct{ Name string }{},
+				struct {
+					ID   int
+					Name string
+				}{},
+			},
+			doesnt: []interface{}{struct{ ID int }{}, struct{ Name bool }{}},
+		},
+		"struct{ _ string; _ int }": {
+			matches: []interface{}{
+				struct {
+					A int
+					B string
+				}{},
+			},
+			doesnt: []interface{}{
+				struct {
+					A string
+					B string
+				}{},
+			},
+		},
+		"struct{ _ string `json:\"id\"`; ... }": {
+			matches: []interface{}{
+				struct {
+					ID   string `json:"id"`
+					Name string
+				}{},
+			},
+			doesnt: []interface{}{
+				struct {
+					ID string `json:"id,omitempty"`
+				}{},
+				struct {
+					ID int `json:"id"`
+				}{},
+				struct {
+					ID string
+				}{},
+			},
+		},
+		"struct{ _ _ `json:\"id*\" db`; ... }": {
+			matches: []interface{}{
+				struct {
+					ID int `json:"id,omitempty" db:"id"`
+				}{},
+				struct {
+					ID int `json:"id" db:""`
+				}{},
+			},
+			doesnt: []interface{}{
+				struct {
+					ID int `json:"id"`
+				}{},
+				struct {
+					ID int `json:"name" db:"id"`
+				}{},
+			},
+		},
+		"interface{ Read([]byte) (int, error) }": {
+			matches: []interface{}{readerType},
+			doesnt:  []interface{}{readCloserType, reader{}, errorType},
+		},
+		"interface{ Read([]byte) (int, error); ... }": {
+			matches: []interface{}{readerType, readCloserType, reader{}, &readCloser{}, readCloser{}},
+			doesnt:  []interface{}{errorType, 0},
+		},
+		"interface{ Close() _; Read(_) (int, error) }": {
+			matches: []interface{}{readCloserType},
+			doesnt:  []interface{}{readerType, &readCloser{}},
+		},
+		"interface{ Close() error; ... }": {
+			matches: []interface{}{&readCloser{}},
+			doesnt:  []interface{}{readCloser{}},
+		},
+		"has[Close() error, Name() string]": {
+			matches: []interface{}{&readCloser{}},
+			doesnt:  []interface{}{readCloser{}, readCloserType, reader{}},
+		},
+		"has*[Close() error, Name() string]": {
+			matches: []interface{}{&readCloser{}, readCloser{}},
+			doesnt:  []interface{}{readCloserType, reader{}},
+		},
+		"has[Read([]byte) (_, error)]": {
+			matches: []interface{}{reader{}, &reader{}, readCloser{}, readerType, readCloserType},
+			doesnt:  []interface{}{0, errorType},
+		},
+		"named[/^[a-z][A-Za-z]*Alias$/]": {
+			matches: []interface{}{stringAlias(""), make(chanIntAlias)},
+			doesnt:  []interface{}{"", reader{}, []stringAlias{}},
+		},
+		"named[kind[string]]": {
+			matches: []interface{}{stringAlias(""), ""},
+			doesnt:  []interface{}{[]string{}, 0},
+		},
+		"unnamed[kind[chan]]": {
+			matches: []interface{}{make(chan int)},
+			doesnt:  []interface{}{make(chanIntAlias), 0},
+		},
+		"pkg[io]": {
+			matches: []interface{}{readerType, readCloserType},
+			doesnt:  []interface{}{reader{}, 0},
+		},
+		"pkg[github.com/*/reflext]": {
+			matches: []interface{}{reader{}, stringAlias("")},
+			doesnt:  []interface{}{readerType, &reader{}, ""},
+		},
+		"pkg[github.com/...]": {
+			matches: []interface{}{reader{}},
+			doesnt:  []interface{}{readerType, &reader{}},
+		},
+		"func({_}) \\1": {
+			matches: []interface{}{func(int) int { return 0 }, func(string) string { return "" }},
+			doesnt:  []interface{}{func(int) uint { return 0 }, func(int) {}},
+		},
+		"map[{k: _}]\\k": {
+			matches: []interface{}{map[int]int{}, map[string]string{}},
+			doesnt:  []interface{}{map[int]string{}},
+		},
+		"func({int} | {uint}, \\2)": {
+			matches: []interface{}{func(uint, uint) {}},
+			doesnt:  []interface{}{func(int, int) {}, func(uint, int) {}},
+		},
+		"kind[ptr] & !*struct": {
+			matches: []interface{}{new(int), new(*someStruct)},
+			doesnt:  []interface{}{&someStruct{}, 0},
+		},
+		"error & !*named[/^myError$/]": {
+			matches: []interface{}{errors.New("")},
+			doesnt:  []interface{}{&myError{}, 0},
+		},
+		"!int | uint & _": {
+			matches: []interface{}{"", uint(0)},
+			doesnt:  []interface{}{0},
+		},
+		"[]struct | []*struct": {
+			matches: []interface{}{[]someStruct{}, []*someStruct{}},
+			doesnt:  []interface{}{[]int{}, someStruct{}},
+		},
 		"alias[string]": {
 			matches: []interface{}{stringAlias("")},
 			doesnt:  []interface{}{""},
@@ -70,6 +283,113 @@ func (_ *ReflextSuite) TestMatchInType(c *C) {
 			matches: []interface{}{make(chanIntAlias)},
 			doesnt:  []interface{}{make(chan int)},
 		},
+		"alias[int]": {
+			matches: []interface{}{myInt(0)},
+			doesnt:  []interface{}{0, celsius(0), int64(0)},
+		},
+		"alias[[]byte]": {
+			matches: []interface{}{bytesAlias{}},
+			doesnt:  []interface{}{[]byte{}, stringAlias(""), []int8{}},
+		},
+		"alias[[]error]": {
+			matches: []interface{}{errs{}},
+			doesnt:  []interface{}{myErrors{}, []error{}},
+		},
+		"alias[map[string]error]": {
+			matches: []interface{}{errorsByName{}},
+			doesnt:  []interface{}{myErrorsByName{}, map[string]error{}},
+		},
+		"convertible[string]": {
+			matches: []interface{}{stringAlias(""), bytesAlias{}, 0, myInt(0)},
+			doesnt:  []interface{}{"", []rune{}, true, 1.5, []int{}},
+		},
+		"convertible[int]": {
+			matches: []interface{}{celsius(0), int8(0), myInt(0), 1.5},
+			doesnt:  []interface{}{0, "", true},
+		},
+		"alias[kind[int]]": {
+			matches: []interface{}{0, myInt(0)},
+			doesnt:  []interface{}{[]int{}, int8(0)},
+		},
+		"alias[_]": {
+			matches: []interface{}{0, types["error"], stringAlias("")},
+			doesnt:  []interface{}{[]int{}, struct{}{}},
+		},
+		"exact[error]": {
+			matches: []interface{}{types["error"]},
+			doesnt:  []interface{}{&myError{}, errors.New(""), types["int"]},
+		},
+		"exact[int]": {
+			matches: []interface{}{0},
+			doesnt:  []interface{}{myInt(0)},
+		},
+		"impl[error]": {
+			matches: []interface{}{&myError{}, myError{}, types["error"], reflect.TypeOf((*failure)(nil)).Elem()},
+			doesnt:  []interface{}{0, reflect.TypeOf((*someInterface)(nil)).Elem()},
+		},
+		"assignable[error]": {
+			matches: []interface{}{&myError{}, types["error"]},
+			doesnt:  []interface{}{myError{}, 0},
+		},
+		"assignable[[]byte]": {
+			matches: []interface{}{[]byte{}, bytesAlias{}},
+			doesnt:  []interface{}{stringAlias(""), []int8{}},
+		},
+		"signed": {
+			matches: []interface{}{0, int8(0), int64(0), myInt(0)},
+			doesnt:  []interface{}{uint(0), 1.5, ""},
+		},
+		"unsigned": {
+			matches: []interface{}{uint(0), uint8(0), uintptr(0)},
+			doesnt:  []interface{}{0, 1.5},
+		},
+		"numeric": {
+			matches: []interface{}{0, uint16(0), 1.5, complex64(0), celsius(0)},
+			doesnt:  []interface{}{"", true, []int{}},
+		},
+		"ordered": {
+			matches: []interface{}{"", 0, 1.5, uintptr(0), stringAlias("")},
+			doesnt:  []interface{}{true, complex128(0), []int{}},
+		},
+		"comparable": {
+			matches: []interface{}{0, "", struct{ A int }{}, [2]int{}, &node{}, types["error"]},
+			doesnt:  []interface{}{[]int{}, map[int]int{}, func() {}, struct{ A []int }{}},
+		},
+		"map[comparable & !ordered]signed": {
+			matches: []interface{}{map[bool]int{}, map[*int]int8{}},
+			doesnt:  []interface{}{map[string]int{}, map[bool]uint{}},
+		},
+		"any": {
+			matches: []interface{}{0, "", types["error"], types["any"]},
+		},
+		"func(string, ...interface{})": {
+			matches: []interface{}{func(string, ...int) {}, func(string, ...interface{}) {}},
+			doesnt:  []interface{}{func(string, []int) {}},
+		},
+		"func(string, ...any)": {
+			matches: []interface{}{func(string, ...int) {}, func(string, ...interface{}) {}},
+			doesnt:  []interface{}{func(string, []int) {}},
+		},
+		"exact[any]": {
+			matches: []interface{}{types["any"]},
+			doesnt:  []interface{}{0, types["error"]},
+		},
+		"exact[interface{}]": {
+			matches: []interface{}{types["any"]},
+			doesnt:  []interface{}{0, types["error"]},
+		},
+		"unsafe.Pointer": {
+			matches: []interface{}{unsafe.Pointer(nil)},
+			doesnt:  []interface{}{uintptr(0), new(int)},
+		},
+		"kind[unsafe.Pointer]": {
+			matches: []interface{}{unsafe.Pointer(nil)},
+			doesnt:  []interface{}{new(int)},
+		},
+		"alias[convertible[int]]": {
+			matches: []interface{}{celsius(0), myInt(0)},
+			doesnt:  []interface{}{0, []int{}, stringAlias("")},
+		},
 		"_": {
 			matches: []interface{}{0, true, "", map[int]int{}},
 			doesnt:  []interface{}{},
@@ -91,22 +411,127 @@ func (_ *ReflextSuite) TestMatchInType(c *C) {
 				func(bool) int { return 0 },
 			},
 		},
+		"func(string, ..._)": {
+			matches: []interface{}{func(string, ...interface{}) {}, func(string, ...int) {}},
+			doesnt: []interface{}{
+				func(string, []interface{}) {},
+				func(...string) {},
+			},
+		},
+		"func(int, _*) error?": {
+			matches: []interface{}{
+				func(int) {},
+				func(int, bool, string) error { return nil },
+			},
+			doesnt: []interface{}{
+				func() {},
+				func(int) (error, error) { return nil, nil },
+				func(int) bool { return true },
+			},
+		},
+		"func(_{1,3}) (_, error)": {
+			matches: []interface{}{
+				func(int) (int, error) { return 0, nil },
+				func(int, int, int) (int, error) { return 0, nil },
+			},
+			doesnt: []interface{}{
+				func() (int, error) { return 0, nil },
+				func(int, int, int, int) (int, error) { return 0, nil },
+			},
+		},
+		"func(int+, int, bool?)": {
+			matches: []interface{}{
+				func(int, int) {},
+				func(int, int, int, bool) {},
+			},
+			doesnt: []interface{}{
+				func(int) {},
+				func(int, bool) {},
+				func(int, int, bool, bool) {},
+			},
+		},
+		"func(_*, ...int)": {
+			matches: []interface{}{func(...int) {}, func(bool, string, ...int) {}},
+			doesnt:  []interface{}{func(bool, int) {}},
+		},
+		"func(string, []_)": {
+			matches: []interface{}{func(string, []interface{}) {}},
+			doesnt:  []interface{}{func(string, ...interface{}) {}},
+		},
+		"rec L. *struct{ Next L; ... }": {
+			matches: []interface{}{&node{}, new(struct{ Next *node })},
+			doesnt:  []interface{}{&tree{}, new(struct{ Next *int }), node{}},
+		},
+		"rec L. *struct{ Next {L}; ... }": {
+			matches: []interface{}{&node{}},
+			doesnt:  []interface{}{&tree{}, node{}},
+		},
+		"rec L. {*struct{ Left L; Right L }}": {
+			matches: []interface{}{&tree{}},
+			doesnt:  []interface{}{&node{}, tree{}},
+		},
+		"rec T. *struct{ Left T; Right T } | *int": {
+			matches: []interface{}{&tree{}, new(int), new(struct{ Left, Right *int })},
+			doesnt:  []interface{}{&node{}, new(struct{ Left, Right *bool })},
+		},
+		"rec L. []L": {
+			matches: []interface{}{nested{}, [][]nested{}},
+			doesnt:  []interface{}{[]int{}, [][]int{}},
+		},
+		"map[string](rec J. string | []J | map[string]J)": {
+			matches: []interface{}{map[string]string{}, map[string][]string{}, map[string]map[string][]string{}},
+			doesnt:  []interface{}{map[string]int{}, map[string][]int{}},
+		},
 	}
 	for s, cases := range examples {
 		r := MustCompile(s)
 		for _, value := range cases.matches {
-			t := reflect.TypeOf(value)
+			t := typeOf(value)
 			c.Logf("%s matches %s", s, t)
 			c.Assert(r.MatchInType(t), Equals, true)
 		}
 		for _, value := range cases.doesnt {
-			t := reflect.TypeOf(value)
+			t := typeOf(value)
 			c.Logf("%s does not match %s", s, t)
 			c.Assert(r.MatchInType(t), Equals, false)
 		}
 	}
 }
 
+func (_ *ReflextSuite) TestMatchInType_alias(c *C) {
+	examples := []struct {
+		typ             interface{}
+		matches, doesnt []interface{}
+	}{
+		{struct{ X, Y int }{}, []interface{}{point{}}, []interface{}{struct{ X, Y int }{}, struct{ X, Y int64 }{}}},
+		{func(int) error { return nil }, []interface{}{handlerFunc(nil)}, []interface{}{func(int) {}}},
+		{types["error"], []interface{}{reflect.TypeOf((*failure)(nil)).Elem()}, []interface{}{&myError{}, types["error"]}},
+		{map[string]int{}, nil, []interface{}{map[string]myInt{}}},
+	}
+	for _, eg := range examples {
+		r := MustCompile("alias[%T]", eg.typ)
+		for _, value := range eg.matches {
+			t := typeOf(value)
+			c.Logf("%s matches %s", r, t)
+			c.Assert(r.MatchInType(t), Equals, true)
+			c.Assert(r.expression.Match(t, newGroups(len(r.names)), accept), Equals, true)
+		}
+		for _, value := range eg.doesnt {
+			t := typeOf(value)
+			c.Logf("%s does not match %s", r, t)
+			c.Assert(r.MatchInType(t), Equals, false)
+			c.Assert(r.expression.Match(t, newGroups(len(r.names)), accept), Equals, false)
+		}
+	}
+}
+
+func typeOf(value interface{}) reflect.Type {
+	if t, ok := value.(reflect.Type); ok {
+		return t
+	}
+	return reflect.TypeOf(value)
+}
+
 func (_ *ReflextSuite) TestFindAllInType(c *C) {
 	examples := map[string][]struct {
 		value    interface{}
@@ -127,6 +552,14 @@ func (_ *ReflextSuite) TestFindAllInType(c *C) {
 			{make(map[string]int), []reflect.Type{}},
 			{uint(0), []reflect.Type{}},
 		},
+		"map[string](int | uint)": {
+			{make(map[string]int), []reflect.Type{}},
+			{make(map[string]uint), []reflect.Type{}},
+		},
+		"[]int | uint": {
+			{[]int{}, []reflect.Type{}},
+			{uint(0), []reflect.Type{}},
+		},
 		"map[string]{int | uint}": {
 			{make(map[string]int), []reflect.Type{types["int"]}},
 			{make(map[string]uint), []reflect.Type{types["uint"]}},
@@ -137,12 +570,48 @@ func (_ *ReflextSuite) TestFindAllInType(c *C) {
 		"func() {_}": {
 			{func() int { return 0 }, []reflect.Type{types["int"]}},
 		},
+		"func(string, ...{_})": {
+			{func(string, ...bool) {}, []reflect.Type{types["bool"]}},
+		},
+		"func(_*, {_}) {int}?": {
+			{func(bool, string) {}, []reflect.Type{types["string"], nil}},
+			{func(string) int { return 0 }, []reflect.Type{types["string"], types["int"]}},
+		},
+		"struct{ Name string; ID {_}; ... }": {
+			{struct {
+				Name string
+				ID   uint
+				Age  int
+			}{}, []reflect.Type{types["uint"]}},
+		},
+		"struct{ _ {int | string}; _ {int} }": {
+			{struct {
+				A int
+				B string
+			}{}, []reflect.Type{types["string"], types["int"]}},
+		},
+		"interface{ Read({_}) ({_}, error); ... }": {
+			{reader{}, []reflect.Type{reflect.TypeOf([]byte{}), types["int"]}},
+		},
+		"has*[Name() {_}]": {
+			{readCloser{}, []reflect.Type{types["string"]}},
+		},
+		"func({_}, []\\1) \\1": {
+			{func(int, []int) int { return 0 }, []reflect.Type{types["int"]}},
+		},
+		"{_} & {kind[int]}": {
+			{0, []reflect.Type{types["int"], types["int"]}},
+		},
 		"{%T}": {
 			{&myError{}, []reflect.Type{reflect.TypeOf(&myError{})}},
 		},
 	}
 	for s, cases := range examples {
-		r := MustCompile(s, reflect.TypeOf((*error)(nil)).Elem())
+		var args []interface{}
+		if strings.Contains(s, "%T") {
+			args = append(args, reflect.TypeOf((*error)(nil)).Elem())
+		}
+		r := MustCompile(s, args...)
 		for _, eg := range cases {
 			t := reflect.TypeOf(eg.value)
 			c.Logf("%s with %s", s, t)
@@ -153,6 +622,277 @@ func (_ *ReflextSuite) TestFindAllInType(c *C) {
 	}
 }
 
+func (_ *ReflextSuite) TestFindAllInType_failedBranchesAreRolledBack(c *C) {
+	examples := map[string][]struct {
+		value    interface{}
+		expected []reflect.Type
+	}{
+		"func({_}, int) | func(_, bool)": {
+			{func(string, bool) {}, []reflect.Type{nil}},
+			{func(string, int) {}, []reflect.Type{types["string"]}},
+		},
+		"map[{_}]int | map[_]{_}": {
+			{map[string]bool{}, []reflect.Type{nil, types["bool"]}},
+		},
+		"func(map[{_}]int | map[_]{_}) ({_}, int) | func(_) (_, {_})": {
+			{func(map[int]bool) (bool, int) { return false, 0 }, []reflect.Type{nil, types["bool"], types["bool"], nil}},
+			{func(map[int]int) (bool, bool) { return false, false }, []reflect.Type{nil, nil, nil, types["bool"]}},
+		},
+		"func({_}*, int, int)": {
+			{func(string, int, int) {}, []reflect.Type{types["string"]}},
+			{func(int, int) {}, []reflect.Type{nil}},
+		},
+		"struct{ _ {int} | {string}; A _ }": {
+			{struct {
+				A int
+				B string
+			}{}, []reflect.Type{nil, types["string"]}},
+		},
+		"func({_}) \\1 | func(_) {_}": {
+			{func(int) bool { return false }, []reflect.Type{nil, types["bool"]}},
+		},
+	}
+	for s, cases := range examples {
+		r := MustCompile(s)
+		for _, eg := range cases {
+			t := reflect.TypeOf(eg.value)
+			c.Logf("%s with %s", s, t)
+			m, ok := r.FindMatchInType(t)
+			c.Assert(ok, Equals, true)
+			for i, expected := range eg.expected {
+				typ, ok := m.Group(i)
+				c.Assert(typ, Equals, expected)
+				c.Assert(ok, Equals, expected != nil)
+			}
+		}
+	}
+}
+
+func (_ *ReflextSuite) TestFindMatchInType_captures(c *C) {
+	examples := map[string][]struct {
+		value    interface{}
+		expected [][]reflect.Type
+	}{
+		"func(int, {args: _}*)": {
+			{func(int) {}, [][]reflect.Type{nil}},
+			{func(int, string, bool) {}, [][]reflect.Type{{types["string"], types["bool"]}}},
+		},
+		"func(({int} | {_})*, bool)": {
+			{func(int, string, int, bool) {}, [][]reflect.Type{
+				{types["int"], types["int"]},
+				{types["string"]},
+			}},
+		},
+		"rec L. *struct{ Value {_}; Next L }": {
+			{&node{}, [][]reflect.Type{{types["int"]}}},
+		},
+		"[]*?{struct}": {
+			{[]node{}, [][]reflect.Type{{reflect.TypeOf(node{})}}},
+			{[]*node{}, [][]reflect.Type{{reflect.TypeOf(node{})}}},
+		},
+		"deref[{_}]": {
+			{0, [][]reflect.Type{{types["int"]}}},
+			{new(**int), [][]reflect.Type{{types["int"]}}},
+		},
+		"func({_}*, {_}, int) {_}": {
+			{func(bool, string, int) uint { return 0 }, [][]reflect.Type{
+				{types["bool"]},
+				{types["string"]},
+				{types["uint"]},
+			}},
+		},
+	}
+	for s, cases := range examples {
+		r := MustCompile(s)
+		for _, eg := range cases {
+			t := reflect.TypeOf(eg.value)
+			c.Logf("%s with %s", s, t)
+			m, ok := r.FindMatchInType(t)
+			c.Assert(ok, Equals, true)
+			for i, expected := range eg.expected {
+				c.Assert(m.Captures(i), DeepEquals, expected)
+				last, ok := m.Group(i)
+				c.Assert(ok, Equals, len(expected) != 0)
+				if ok {
+					c.Assert(last, Equals, expected[len(expected)-1])
+				}
+			}
+		}
+	}
+
+	m, _ := MustCompile("func(int, {args: _}*)").FindMatch(func(int, bool, bool) {})
+	c.Assert(m.NamedCaptures("args"), DeepEquals, []reflect.Type{types["bool"], types["bool"]})
+	c.Assert(m.NamedCaptures("missing"), IsNil)
+
+	m, _ = MustCompile("struct{ Key [{n}]byte; Value {_} }").FindMatch(struct {
+		Key   [32]byte
+		Value int
+	}{})
+	n, ok := m.Len(0)
+	c.Assert(ok, Equals, true)
+	c.Assert(n, Equals, 32)
+	_, ok = m.Len(1)
+	c.Assert(ok, Equals, false)
+}
+
+func (_ *ReflextSuite) TestFindMatchInType(c *C) {
+	r := MustCompile("func({ctx: _}, {req: *struct} | {{int} | {uint}}) {_}")
+	c.Assert(r.NumSubexp(), Equals, 6)
+	c.Assert(r.SubexpNames(), DeepEquals, []string{"ctx", "req", "", "", "", ""})
+	c.Assert(r.SubexpIndex("req"), Equals, 1)
+	c.Assert(r.SubexpIndex("missing"), Equals, -1)
+	c.Assert(r.SubexpIndex(""), Equals, -1)
+
+	m, ok := r.FindMatch(func(string, uint) bool { return true })
+	c.Assert(ok, Equals, true)
+	c.Assert(m.NumGroup(), Equals, 6)
+	c.Assert(m.SubexpNames(), DeepEquals, r.SubexpNames())
+	for i, expected := range []struct {
+		typ reflect.Type
+		ok  bool
+	}{
+		{types["string"], true},
+		{nil, false},
+		{types["uint"], true},
+		{nil, false},
+		{types["uint"], true},
+		{types["bool"], true},
+	} {
+		typ, ok := m.Group(i)
+		c.Assert(typ, Equals, expected.typ)
+		c.Assert(ok, Equals, expected.ok)
+	}
+	typ, ok := m.Named("ctx")
+	c.Assert(typ, Equals, types["string"])
+	c.Assert(ok, Equals, true)
+	typ, ok = m.Named("req")
+	c.Assert(typ, IsNil)
+	c.Assert(ok, Equals, false)
+	typ, ok = m.Named("missing")
+	c.Assert(typ, IsNil)
+	c.Assert(ok, Equals, false)
+
+	_, ok = r.FindMatch(0)
+	c.Assert(ok, Equals, false)
+}
+
+func (_ *ReflextSuite) TestFindAllMatchesInType(c *C) {
+	examples := map[string][]struct {
+		value    interface{}
+		expected [][]reflect.Type
+	}{
+		"{_} | {int}": {
+			{0, [][]reflect.Type{{types["int"], nil}, {nil, types["int"]}}},
+			{true, [][]reflect.Type{{types["bool"], nil}}},
+		},
+		"func({_}*, {_}*)": {
+			{func(int, bool) {}, [][]reflect.Type{
+				{types["bool"], nil},
+				{types["int"], types["bool"]},
+				{nil, types["bool"]},
+			}},
+		},
+		"struct{ _ {_}; ... }": {
+			{struct {
+				A int
+				B string
+			}{}, [][]reflect.Type{{types["int"]}, {types["string"]}}},
+		},
+		"map[{_} | {int}]{_} | {map[int]_}": {
+			{map[int]bool{}, [][]reflect.Type{
+				{types["int"], nil, types["bool"], nil},
+				{nil, types["int"], types["bool"], nil},
+				{nil, nil, nil, reflect.TypeOf(map[int]bool{})},
+			}},
+		},
+		"{int}": {
+			{"", nil},
+		},
+		"_ | _": {
+			{0, [][]reflect.Type{nil}},
+		},
+		"func(_*, _*)": {
+			{func(int, string) {}, [][]reflect.Type{nil}},
+		},
+		"{_ | _}": {
+			{0, [][]reflect.Type{{types["int"]}}},
+		},
+		"struct{ _ _; _ {int}; ... }": {
+			{struct {
+				A int
+				B int
+				C string
+			}{}, [][]reflect.Type{{types["int"]}}},
+		},
+	}
+	for s, cases := range examples {
+		r := MustCompile(s)
+		for _, eg := range cases {
+			t := reflect.TypeOf(eg.value)
+			c.Logf("%s with %s", s, t)
+			var actual [][]reflect.Type
+			r.FindAllMatchesInType(t, func(m *MatchResult) bool {
+				var types []reflect.Type
+				for i := 0; i < m.NumGroup(); i++ {
+					typ, ok := m.Group(i)
+					c.Assert(ok, Equals, typ != nil)
+					types = append(types, typ)
+				}
+				actual = append(actual, types)
+				return true
+			})
+			c.Assert(actual, DeepEquals, eg.expected)
+		}
+	}
+}
+
+func (_ *ReflextSuite) TestFindAllMatches_stop(c *C) {
+	r := MustCompile("func({_}*, {_}*)")
+	var count int
+	r.FindAllMatches(func(int, bool) {}, func(m *MatchResult) bool {
+		count++
+		return false
+	})
+	c.Assert(count, Equals, 1)
+}
+
+func (_ *ReflextSuite) TestFindAllMatches_many(c *C) {
+	in := make([]reflect.Type, 100)
+	for i := range in {
+		in[i] = types["int"]
+	}
+	t := reflect.FuncOf(in, nil, false)
+	for s, expected := range map[string]int{
+		"func({_}*, {_}*)":  len(in) + 1,
+		"func(_*, {_}, _*)": 1,
+	} {
+		c.Log(s)
+		var count int
+		MustCompile(s).FindAllMatchesInType(t, func(m *MatchResult) bool {
+			count++
+			return true
+		})
+		c.Assert(count, Equals, expected)
+	}
+}
+
+func (_ *ReflextSuite) TestSplice(c *C) {
+	inner := MustCompile("map[{_}]\\1")
+	r := MustCompile("func({_}, %v, %[1]v) {_}", inner)
+	c.Assert(r.String(), Equals, "func({_}, map[{_}]\\2, map[{_}]\\3) {_}")
+	captures, ok := r.FindAll(func(int, map[string]string, map[bool]bool) uint { return 0 })
+	c.Assert(ok, Equals, true)
+	c.Assert(captures, DeepEquals, []reflect.Type{types["int"], types["string"], types["bool"], types["uint"]})
+	c.Assert(r.Match(func(int, map[string]string, map[bool]int) uint { return 0 }), Equals, false)
+
+	u := new(Universe).With("Thing", stringAlias(""))
+	r = MustCompile("map[string]%v", u.MustCompile("[]Thing"))
+	c.Assert(r.Match(map[string][]stringAlias{}), Equals, true)
+
+	_, err := Compile("%v | %[1]v", MustCompile("{k: _}"))
+	c.Assert(err, ErrorMatches, "group k is defined more than once")
+}
+
 func (_ *ReflextSuite) TestString(c *C) {
 	r := MustCompile("map[int]bool")
 	c.Assert(r.String(), Equals, "map[int]bool")
@@ -160,27 +900,82 @@ func (_ *ReflextSuite) TestString(c *C) {
 
 func (_ *ReflextSuite) TestString_expression(c *C) {
 	examples := map[string]string{
-		"int":                                 "",
-		"[2]bool":                             "",
-		"[]bool":                              "",
-		"[][]rune":                            "[][]int32",
-		"*string":                             "",
-		"map[byte]error":                      "map[uint8]error",
-		"chan int":                            "",
-		"chan<- int":                          "",
-		"<-chan int":                          "",
-		"kind[uint8]":                         "",
-		"struct":                              "kind[struct]",
-		"alias[chan uint8]":                   "",
-		"_":                                   "",
-		"{int}":                               "",
-		"map[{_}]*{_}":                        "",
-		"{{int | {_}}}":                       "",
-		"func(int) byte":                      "func(int) uint8",
-		"func(int) (int, int)":                "",
-		"func(int | uint, bool) (int, int)":   "",
-		"int | uint":                          "",
-		"int | kind[int] | uint | kind[uint]": "",
+		"int":                                "",
+		"[2]bool":                            "",
+		"[]bool":                             "",
+		"[][]rune":                           "[][]int32",
+		"*string":                            "",
+		"map[byte]error":                     "map[uint8]error",
+		"chan int":                           "",
+		"chan<- int":                         "",
+		"<-chan int":                         "",
+		"kind[uint8]":                        "",
+		"struct":                             "kind[struct]",
+		"alias[chan uint8]":                  "",
+		"_":                                  "",
+		"{int}":                              "",
+		"map[{_}]*{_}":                       "",
+		"{{int | {_}}}":                      "",
+		"func(int) byte":                     "func(int) uint8",
+		"func(int) (int, int)":               "",
+		"func(int | uint, bool) (int, int)":  "",
+		"func(string, ...{_})":               "",
+		"func(_*, int+, bool?) error?":       "",
+		"func(_{2}, _{2,}, _{1,3})":          "",
+		"func(_{0,}, _{0,1}) (_, error*)":    "func(_*, _?) (_, error*)",
+		"struct{}":                           "",
+		"struct{ ... }":                      "",
+		"struct{ Name string; ID {_}; ... }": "",
+		"struct{ F func(); G int | uint }":   "",
+		"struct{A int;}":                     "struct{ A int }",
+		"struct{ A int `db json:\"id\"` }":   "",
+		"interface{}":                        "any",
+		"interface{ ... }":                   "",
+		"interface{ Read([]uint8) (int, error); Close() error }": "",
+		"interface{ M(int, ...{_}); ... }":                       "",
+		"has[Close() error, Name() string]":                      "",
+		"has*[M(int) error?]":                                    "",
+		"named[/Handler$/]":                                      "",
+		"named[/[a-z]\\/x/]":                                     "",
+		"unnamed[map[string]_]":                                  "",
+		"pkg[github.com/acme/*]":                                 "",
+		"pkg[ github.com/acme/... ]":                             "pkg[github.com/acme/...]",
+		"{req: *struct}":                                         "{req: *kind[struct]}",
+		"map[{k: _}]{v: _}":                                      "",
+		"func({_}) \\1":                                          "",
+		"map[{k: _}]\\k":                                         "",
+		"kind[ptr] & !*struct":                                   "kind[ptr] & !*kind[struct]",
+		"!int | uint & _ & !!bool":                               "",
+		"map[string](int | uint)":                                "",
+		"[](int | uint) | []bool":                                "",
+		"!(int | uint) & (bool | _)":                             "",
+		"(int | uint) | bool & (_ & _)":                          "",
+		"((int))":                                                "int",
+		"func() (int | uint)":                                    "",
+		"func() ((int | uint)?)":                                 "",
+		"func((int | uint)*, (func())?)":                         "",
+		"func((func() int)+) (func() int)":                       "func((func() int)+) func() int",
+		"*(int & _)":                                             "",
+		"struct{ A int `db:\"*\"` }":                             "struct{ A int `db` }",
+		"struct{ A int \"json:\\\"a*\\\"\" }":                    "struct{ A int `json:\"a*\"` }",
+		"int | uint":                                             "",
+		"rec L. *struct{ Next L; ... }":                          "",
+		"(rec L. *L | []L) & !*int":                              "",
+		"func() (rec L. *L)":                                     "",
+		"rec L. rec M. map[L]M":                                  "",
+		"int | kind[int] | uint | kind[uint]":                    "",
+		"exact[error] | exact[int]":                              "exact[error] | int",
+		"signed | unsigned | numeric | ordered":                  "",
+		"comparable & !kind[ptr]":                                "",
+		"map[any]exact[any]":                                     "",
+		"exact[interface{}] | interface{}":                       "exact[any] | any",
+		"unsafe.Pointer | kind[unsafe.Pointer]":                  "",
+		"impl[error] & assignable[[]uint8]":                      "",
+		"[_]uint8 | [1..16]uint8 | [{4}]uint8":                   "[_]uint8 | [1..16]uint8 | {[4]uint8}",
+		"[{n}]uint8":                                             "{n: [_]uint8}",
+		"chan? [2]int":                                           "",
+		"*?*+(int | uint)":                                       "",
+		"deref[*?{_}] & []*?struct":                              "deref[*?{_}] & []*?kind[struct]",
 	}
 	for s, expected := range examples {
 		c.Log(s)
@@ -13,6 +13,8 @@
 package reflext
 
 import (
+	"io"
+	"reflect"
 	"testing"
 
 	. "gopkg.in/check.v1"
@@ -37,3 +39,55 @@ type stringAlias string
 type chanIntAlias chan int
 
 type someInterface interface{}
+
+type reader struct{}
+
+func (reader) Read([]byte) (int, error) { return 0, nil }
+
+type readCloser struct{ reader }
+
+func (*readCloser) Close() error { return nil }
+
+func (readCloser) Name() string { return "" }
+
+var (
+	readerType     = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	readCloserType = reflect.TypeOf((*io.ReadCloser)(nil)).Elem()
+)
+
+type node struct {
+	Value int
+	Next  *node
+}
+
+type tree struct {
+	Left, Right *tree
+}
+
+type nested []nested
+
+type myInt int
+
+type celsius float64
+
+type bytesAlias []byte
+
+type point struct{ X, Y int }
+
+type handlerFunc func(int) error
+
+type failure error
+
+type errs []error
+
+type myErrors []*myError
+
+type errorsByName map[string]error
+
+type myErrorsByName map[string]*myError
+
+type cyclicPtr *cyclicPtr
+
+type cyclicA *cyclicB
+
+type cyclicB *cyclicA
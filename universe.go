@@ -0,0 +1,101 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reflext
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"reflect"
+)
+
+// Universe binds identifiers to types, in addition to the builtin types such
+// as int or error. Identifiers may be qualified, as in time.Duration or
+// net/http.Handler, in which case they are written without spaces in
+// patterns. A Universe is immutable, and the nil *Universe only holds the
+// builtin types.
+type Universe struct {
+	types map[string]reflect.Type
+}
+
+// With returns a copy of the universe where name is bound to typ, given as a
+// reflect.Type or as a value of that type. It panics if name is not a valid
+// identifier, if it is a builtin type such as error, or if it is or starts
+// with a keyword, as in kind.Widget, which patterns could not refer to.
+func (u *Universe) With(name string, typ interface{}) *Universe {
+	p := &parser{tokens: tokenize(name), index: 0}
+	text, _ := p.next()
+	if !isIdent(text) || p.qualified(text) != name || p.index != len(p.tokens) {
+		panic(fmt.Sprintf("reflext: invalid identifier %q", name))
+	}
+	if keywords[text] {
+		panic(fmt.Sprintf("reflext: %s is a keyword", text))
+	}
+	if _, ok := types[name]; ok {
+		panic(fmt.Sprintf("reflext: %s is already bound", name))
+	}
+	types := make(map[string]reflect.Type)
+	if u != nil {
+		for n, t := range u.types {
+			types[n] = t
+		}
+	}
+	types[name] = typeOfArg(typ)
+	return &Universe{types}
+}
+
+func (u *Universe) lookup(name string) (reflect.Type, bool) {
+	if u != nil {
+		if typ, ok := u.types[name]; ok {
+			return typ, true
+		}
+	}
+	typ, ok := types[name]
+	return typ, ok
+}
+
+func (u *Universe) Compile(s string, args ...interface{}) (*Reflext, error) {
+	p := &parser{
+		tokens:   tokenize(s),
+		index:    0,
+		args:     args,
+		universe: u,
+	}
+	return p.compile(s)
+}
+
+func (u *Universe) MustCompile(s string, args ...interface{}) *Reflext {
+	r, err := u.Compile(s, args...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Definitions returns empty definitions whose patterns are compiled in the
+// universe.
+func (u *Universe) Definitions() *Definitions {
+	return &Definitions{universe: u}
+}
+
+// ParseLibrary is like the package's ParseLibrary, with patterns compiled in
+// the universe.
+func (u *Universe) ParseLibrary(filename string, r io.Reader, args ...interface{}) (*Library, error) {
+	return u.Definitions().parseLibrary(nil, filename, r, args)
+}
+
+// ParseLibraryFS is like the package's ParseLibraryFS, with patterns compiled
+// in the universe.
+func (u *Universe) ParseLibraryFS(fsys fs.FS, name string, args ...interface{}) (*Library, error) {
+	return u.Definitions().parseLibrary(fsys, name, nil, args)
+}
@@ -0,0 +1,133 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reflext
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func testUniverse() *Universe {
+	return new(Universe).
+		With("io.Reader", readerType).
+		With("bytes.Buffer", bytes.Buffer{}).
+		With("Node", node{}).
+		With("github.com/pascallouisperez/reflext.tree", tree{})
+}
+
+func (_ *ReflextSuite) TestUniverse_compile(c *C) {
+	u := testUniverse()
+	examples := map[string]struct {
+		matches, doesnt []interface{}
+	}{
+		"func(io.Reader, *bytes.Buffer)": {
+			matches: []interface{}{func(reader, *bytes.Buffer) {}},
+			doesnt:  []interface{}{func(int, *bytes.Buffer) {}},
+		},
+		"[]*Node | github.com/pascallouisperez/reflext.tree": {
+			matches: []interface{}{[]*node{}, tree{}},
+			doesnt:  []interface{}{[]node{}, &tree{}},
+		},
+		"map[string]int": {
+			matches: []interface{}{map[string]int{}},
+		},
+	}
+	for s, cases := range examples {
+		r := u.MustCompile(s)
+		for _, value := range cases.matches {
+			c.Logf("%s matches %T", s, value)
+			c.Assert(r.Match(value), Equals, true)
+		}
+		for _, value := range cases.doesnt {
+			c.Logf("%s does not match %T", s, value)
+			c.Assert(r.Match(value), Equals, false)
+		}
+	}
+	c.Assert(u.MustCompile("*bytes.Buffer").String(), Equals, "*bytes.Buffer")
+}
+
+func (_ *ReflextSuite) TestUniverse_unknown(c *C) {
+	u := testUniverse()
+	examples := map[string]string{
+		"http.Request":                "unknown identifier http.Request",
+		"func(int, net/http.Handler)": "unknown identifier net/http.Handler",
+		"io .Reader":                  "unknown identifier io",
+		"struct{ A Reader }":          "unknown identifier Reader",
+		"Context":                     "unknown identifier Context",
+	}
+	for s, expected := range examples {
+		c.Log(s)
+		_, err := u.Compile(s)
+		c.Assert(err, ErrorMatches, expected)
+	}
+
+	_, err := Compile("io.Reader")
+	c.Assert(err, ErrorMatches, "unknown identifier io.Reader")
+}
+
+func (_ *ReflextSuite) TestUniverse_immutable(c *C) {
+	u := testUniverse()
+	u2 := u.With("Context", 0)
+	_, err := u.Compile("Context")
+	c.Assert(err, NotNil)
+	c.Assert(u2.MustCompile("Context").Match(0), Equals, true)
+	c.Assert(u2.MustCompile("io.Reader").Match(reader{}), Equals, true)
+	c.Assert((*Universe)(nil).MustCompile("int").Match(0), Equals, true)
+}
+
+func (_ *ReflextSuite) TestUniverse_invalidIdentifier(c *C) {
+	for _, name := range []string{"", "_x y", "io.", "io. Reader", "*int", "[]x"} {
+		c.Log(name)
+		c.Assert(func() { new(Universe).With(name, 0) }, PanicMatches, "reflext: invalid identifier .*")
+	}
+}
+
+func (_ *ReflextSuite) TestUniverse_reserved(c *C) {
+	examples := map[string]string{
+		"error":          "reflext: error is already bound",
+		"int":            "reflext: int is already bound",
+		"any":            "reflext: any is already bound",
+		"unsafe.Pointer": "reflext: unsafe.Pointer is already bound",
+		"signed":         "reflext: signed is a keyword",
+		"rec":            "reflext: rec is a keyword",
+		"pkg":            "reflext: pkg is a keyword",
+		"_":              "reflext: _ is a keyword",
+		"kind.Widget":    "reflext: kind is a keyword",
+		"pkg.X":          "reflext: pkg is a keyword",
+		"rec.X":          "reflext: rec is a keyword",
+		"has.Field":      "reflext: has is a keyword",
+		"named/x.Y":      "reflext: named is a keyword",
+	}
+	for name, expected := range examples {
+		c.Log(name)
+		c.Assert(func() { new(Universe).With(name, 0) }, PanicMatches, regexp.QuoteMeta(expected))
+	}
+}
+
+func (_ *ReflextSuite) TestUniverse_library(c *C) {
+	lib, err := testUniverse().ParseLibrary("lib.reflext", strings.NewReader("Read := func(io.Reader, []*Node)"))
+	c.Assert(err, IsNil)
+	c.Assert(lib.MustCompile("@Read").Match(func(reader, []*node) {}), Equals, true)
+
+	defs := testUniverse().Definitions()
+	defs.Define("Buffers := []*bytes.Buffer")
+	lib, err = defs.Library()
+	c.Assert(err, IsNil)
+	c.Assert(lib.MustCompile("@Buffers").Match([]*bytes.Buffer{}), Equals, true)
+
+	_, err = ParseLibrary("lib.reflext", strings.NewReader("Read := func(io.Reader)"))
+	c.Assert(err, ErrorMatches, "lib.reflext:1:17: unknown identifier io.Reader")
+}